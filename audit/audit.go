@@ -0,0 +1,378 @@
+package audit
+
+////
+//// Append-only, hash-chained audit log of the changes made through the agent API. One JSON record per line
+////
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxSize  = 100 // Default size of the audit log file before rotation, in MB
+	DefaultMaxFiles = 10  // Default number of rotated audit log files kept
+)
+
+// Audit record of a single agent API change
+type Record struct {
+	Seq      uint64   `json:"seq"`           // Sequence number, across rotations
+	Time     string   `json:"time"`          // RFC 3339 UTC timestamp
+	Client   string   `json:"client"`        // Client identity
+	Method   string   `json:"method"`        // HTTP method
+	Route    string   `json:"route"`         // Request path
+	Resource string   `json:"resource"`      // Agent API resource, e.g. "containers"
+	Object   string   `json:"object"`        // Object name
+	Status   int      `json:"status"`        // HTTP response status. 0: Change about to be made (fail-closed auditing), followed by a record with the outcome
+	Before   string   `json:"before"`        // Digest of the object before the change. Empty: No such object
+	After    string   `json:"after"`         // Digest of the object after the change. Empty: No such object
+	VSD      []string `json:"vsd,omitempty"` // Resulting VSD operations
+	Prev     string   `json:"prev"`          // Hash of the previous record. Empty for the first record
+	Hash     string   `json:"hash"`          // Hash of this record, computed with an empty "Hash"
+}
+
+// Sequence number and hash of the last record of an audit log, kept outside of the log in its head file ("<file>.head").
+// Records removed from the end of the log -- which leaves a valid chain -- are detected against it
+type Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Audit log file, rotated by size. Rotated files are suffixed with ".1" (most recent) to ".<max files>" (oldest)
+type Log struct {
+	file     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+	seq  uint64
+	last string // Hash of the last record
+
+	mutex sync.Mutex
+}
+
+// Open -- or create -- an audit log file. The hash chain continues from the last record of the existing log, if any.
+// Sizes are in MB. Zero values use the defaults
+func Open(file string, maxSize, maxFiles int) (*Log, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+
+	l := &Log{file: file, maxSize: int64(maxSize) << 20, maxFiles: maxFiles}
+
+	// Resume the chain from the current file or -- if empty -- from the most recent rotated one
+	for _, f := range []string{file, rotated(file, 1)} {
+		if last, err := lastRecord(f); err != nil {
+			return nil, err
+		} else if last != nil {
+			l.seq, l.last = last.Seq, last.Hash
+			break
+		}
+	}
+
+	// The chain must resume from the recorded head, if any. Logs without one (e.g. from earlier agent versions) get one from now on
+	head, err := readHead(HeadFile(file))
+	if err != nil {
+		return nil, err
+	}
+	// XXX - A head behind the log is left by a failure to update it (see "Append"), and is only checked by "Verify"
+	if head != nil && (head.Seq > l.seq || head.Seq == l.seq && head.Hash != l.last) {
+		return nil, fmt.Errorf("Audit log: %s ends at record: %d instead of record: %d in: %s. Records were removed from the end of the log", file, l.seq, head.Seq, HeadFile(file))
+	}
+	if err := writeHead(HeadFile(file), &Head{Seq: l.seq, Hash: l.last}); err != nil {
+		return nil, err
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Head file of an audit log file
+func HeadFile(file string) string {
+	return file + ".head"
+}
+
+// Append a record to the audit log, chaining it to the previous one.
+// Failing to update the head file once the record is written gives a "*HeadError": The record is part of the chain regardless
+func (l *Log) Append(r *Record) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	r.Seq = l.seq + 1
+	if r.Time == "" {
+		r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	r.Prev = l.last
+	r.Hash = ""
+	r.Hash = hash(r)
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if l.size+int64(len(data)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if n, err := l.f.Write(data); err != nil {
+		// No partial record is left behind, so the next one follows the last complete one
+		if n > 0 {
+			if terr := l.f.Truncate(l.size); terr != nil {
+				l.size += int64(n)
+				return fmt.Errorf("Partial audit record: %d left in: %s. Write error: %s. Truncate error: %s", r.Seq, l.file, err, terr)
+			}
+		}
+		return err
+	}
+
+	l.size += int64(len(data))
+	l.seq, l.last = r.Seq, r.Hash
+
+	if err := writeHead(HeadFile(l.file), &Head{Seq: r.Seq, Hash: r.Hash}); err != nil {
+		return &HeadError{File: HeadFile(l.file), Err: err}
+	}
+	return nil
+}
+
+// Failure to record the head of an audit log. The head is behind the log until the next record is appended
+type HeadError struct {
+	File string
+	Err  error
+}
+
+func (e *HeadError) Error() string {
+	return fmt.Sprintf("Cannot update audit log head file: %s. Error: %s", e.File, e.Err)
+}
+
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.f.Close()
+}
+
+// Verify the hash chain of an audit log file and its rotated files, oldest first, and that the chain ends at the recorded head -- or goes past it, if the head could not be updated. Returns the number of verified records.
+// XXX - The first record of the oldest file available is trusted as the anchor of the chain
+func Verify(file string) (int, error) {
+	head, err := readHead(HeadFile(file))
+	if err != nil {
+		return 0, err
+	}
+	if head == nil {
+		return 0, fmt.Errorf("No head file: %s. Records removed from the end of the log cannot be detected", HeadFile(file))
+	}
+
+	files := []string{file}
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated(file, i)); err != nil {
+			break
+		}
+		files = append([]string{rotated(file, i)}, files...)
+	}
+
+	count := 0
+	var prev *Record
+	passed := head.Seq == 0 // Whether the chain goes through the head record
+
+	for _, f := range files {
+		fd, err := os.Open(f)
+		if err != nil {
+			return count, err
+		}
+
+		scanner := bufio.NewScanner(fd)
+		scanner.Buffer(make([]byte, 64*1024), 16<<20)
+		for line := 1; scanner.Scan(); line++ {
+			r := &Record{}
+			if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+				fd.Close()
+				return count, fmt.Errorf("%s:%d: Invalid audit record: %s", f, line, err)
+			}
+
+			recorded := r.Hash
+			r.Hash = ""
+			if hash(r) != recorded {
+				fd.Close()
+				return count, fmt.Errorf("%s:%d: Audit record: %d was altered (hash mismatch)", f, line, r.Seq)
+			}
+			r.Hash = recorded
+
+			if prev != nil && (r.Prev != prev.Hash || r.Seq != prev.Seq+1) {
+				fd.Close()
+				return count, fmt.Errorf("%s:%d: Audit record: %d does not follow record: %d (broken chain)", f, line, r.Seq, prev.Seq)
+			}
+
+			if r.Seq == head.Seq && r.Hash == head.Hash {
+				passed = true
+			}
+
+			prev = r
+			count++
+		}
+		err = scanner.Err()
+		fd.Close()
+		if err != nil {
+			return count, err
+		}
+	}
+
+	last := &Head{}
+	if prev != nil {
+		last.Seq, last.Hash = prev.Seq, prev.Hash
+	}
+	if *last != *head && !(last.Seq > head.Seq && passed) {
+		return count, fmt.Errorf("%s: Audit log ends at record: %d instead of record: %d (records removed from the end)", file, last.Seq, head.Seq)
+	}
+
+	return count, nil
+}
+
+// Digest of an object, as recorded in the "Before" / "After" audit record fields. Empty for nil objects
+func Digest(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+////////
+//////// utils
+////////
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// Shift the rotated files, dropping the oldest one, then start a new file. The chain continues across files
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(rotated(l.file, l.maxFiles))
+	for i := l.maxFiles - 1; i > 0; i-- {
+		os.Rename(rotated(l.file, i), rotated(l.file, i+1))
+	}
+	if err := os.Rename(l.file, rotated(l.file, 1)); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func rotated(file string, i int) string {
+	return fmt.Sprintf("%s.%d", file, i)
+}
+
+// Last record of an audit log file. Nil if the file is missing or empty
+func lastRecord(file string) (*Record, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var last []byte
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 1 {
+			last = line
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if last == nil {
+		return nil, nil
+	}
+
+	r := &Record{}
+	if err := json.Unmarshal(last, r); err != nil {
+		return nil, fmt.Errorf("Invalid last audit record in: %s. Error: %s", file, err)
+	}
+	return r, nil
+}
+
+func hash(r *Record) string {
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Head of an audit log. Nil if the head file is missing
+func readHead(file string) (*Head, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	head := &Head{}
+	if err := json.Unmarshal(data, head); err != nil {
+		return nil, fmt.Errorf("Invalid audit log head file: %s. Error: %s", file, err)
+	}
+	return head, nil
+}
+
+// Replace the head file of an audit log atomically
+func writeHead(file string, head *Head) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
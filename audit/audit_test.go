@@ -0,0 +1,280 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  int
+		maxSize  int64 // Bytes. Zero: No rotation
+		maxFiles int
+		tamper   func(t *testing.T, file string)
+		want     int
+		wantErr  string
+	}{
+		{name: "intact", records: 5, want: 5},
+		{name: "empty", records: 0, want: 0},
+		{name: "intact rotated", records: 12, maxSize: 1000, maxFiles: 10, want: 12},
+		{name: "oldest rotated files dropped", records: 40, maxSize: 1000, maxFiles: 2, want: -1},
+		{
+			name: "altered record", records: 5,
+			tamper:  func(t *testing.T, file string) { replaceInLine(t, file, 2, `"object":"c2"`, `"object":"c9"`) },
+			wantErr: "was altered",
+		},
+		{
+			name: "altered record in a rotated file", records: 12, maxSize: 1000, maxFiles: 10,
+			tamper: func(t *testing.T, file string) {
+				replaceInLine(t, rotated(file, 1), 0, `"method":"PUT"`, `"method":"GET"`)
+			},
+			wantErr: "was altered",
+		},
+		{
+			name: "removed record", records: 5,
+			tamper:  func(t *testing.T, file string) { removeLine(t, file, 2) },
+			wantErr: "broken chain",
+		},
+		{
+			name: "removed rotated file", records: 20, maxSize: 1000, maxFiles: 10,
+			tamper: func(t *testing.T, file string) {
+				if err := os.Rename(rotated(file, 1), file+".gone"); err != nil {
+					t.Fatal(err)
+				}
+				// Close the gap left by the removed file
+				for i := 2; exists(rotated(file, i)); i++ {
+					os.Rename(rotated(file, i), rotated(file, i-1))
+				}
+			},
+			wantErr: "broken chain",
+		},
+		{
+			name: "truncated tail", records: 5,
+			tamper:  func(t *testing.T, file string) { removeLine(t, file, 4) },
+			wantErr: "records removed from the end",
+		},
+		{
+			name: "truncated current file after rotation", records: 12, maxSize: 1000, maxFiles: 10,
+			tamper: func(t *testing.T, file string) {
+				if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "records removed from the end",
+		},
+		{
+			name: "missing head", records: 5,
+			tamper: func(t *testing.T, file string) {
+				if err := os.Remove(HeadFile(file)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "No head file",
+		},
+		{
+			name: "stale head", records: 5,
+			tamper: func(t *testing.T, file string) { setHead(t, file, headOf(t, file, 2)) },
+			want:   5,
+		},
+		{
+			name: "stale head off the chain", records: 5,
+			tamper:  func(t *testing.T, file string) { setHead(t, file, &Head{Seq: 3, Hash: Digest("bogus")}) },
+			wantErr: "records removed from the end",
+		},
+		{
+			name: "invalid record", records: 5,
+			tamper:  func(t *testing.T, file string) { replaceInLine(t, file, 1, `{`, `[`) },
+			wantErr: "Invalid audit record",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "audit")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			file := filepath.Join(dir, "audit.log")
+			writeLog(t, file, test.records, test.maxSize, test.maxFiles)
+			if test.maxSize > 0 && !exists(rotated(file, 1)) {
+				t.Fatalf("No rotated audit log file")
+			}
+			if test.tamper != nil {
+				test.tamper(t, file)
+			}
+
+			count, err := Verify(file)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Verify() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify(): %s", err)
+			}
+			if test.want >= 0 && count != test.want {
+				t.Errorf("Verify() = %d records, want %d", count, test.want)
+			}
+			if test.want < 0 && (count == 0 || count >= test.records) {
+				t.Errorf("Verify() = %d records, want fewer than %d", count, test.records)
+			}
+		})
+	}
+}
+
+// The chain of a reopened log continues from its last record -- unless records were removed from its end
+func TestOpenResume(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, file string)
+		wantErr bool
+	}{
+		{name: "intact"},
+		{name: "truncated tail", tamper: func(t *testing.T, file string) { removeLine(t, file, 2) }, wantErr: true},
+		{name: "stale head", tamper: func(t *testing.T, file string) { setHead(t, file, headOf(t, file, 1)) }},
+		{
+			name: "no head file",
+			tamper: func(t *testing.T, file string) {
+				if err := os.Remove(HeadFile(file)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "audit")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			file := filepath.Join(dir, "audit.log")
+			writeLog(t, file, 3, 0, 0)
+			if test.tamper != nil {
+				test.tamper(t, file)
+			}
+
+			l, err := Open(file, 0, 0)
+			if test.wantErr {
+				if err == nil {
+					l.Close()
+					t.Fatalf("Open(): no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open(): %s", err)
+			}
+			r := &Record{Method: "DELETE", Resource: "containers", Object: "c0"}
+			if err := l.Append(r); err != nil {
+				t.Fatal(err)
+			}
+			l.Close()
+
+			if r.Seq != 4 {
+				t.Errorf("Resumed record: %d, want 4", r.Seq)
+			}
+			if count, err := Verify(file); err != nil || count != 4 {
+				t.Errorf("Verify() = %d, %v, want 4 records", count, err)
+			}
+		})
+	}
+}
+
+////////
+//////// utils
+////////
+
+// Write an audit log with the given number of records, rotating it past "maxSize" bytes
+func writeLog(t *testing.T, file string, records int, maxSize int64, maxFiles int) {
+	l, err := Open(file, 0, maxFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if maxSize > 0 {
+		l.maxSize = maxSize
+	}
+
+	for i := 0; i < records; i++ {
+		r := &Record{
+			Client:   "uid:0",
+			Method:   "PUT",
+			Route:    "/containers/c" + strconv.Itoa(i),
+			Resource: "containers",
+			Object:   "c" + strconv.Itoa(i),
+			Status:   201,
+			After:    Digest(i),
+		}
+		if err := l.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func lines(t *testing.T, file string) [][]byte {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+// Replace the first occurrence of a string in a given line (from 0) of a file
+func replaceInLine(t *testing.T, file string, line int, old, new string) {
+	ls := lines(t, file)
+	if !bytes.Contains(ls[line], []byte(old)) {
+		t.Fatalf("%s:%d: No %q in: %s", file, line, old, ls[line])
+	}
+	ls[line] = bytes.Replace(ls[line], []byte(old), []byte(new), 1)
+	writeLines(t, file, ls)
+}
+
+// Remove a given line (from 0) of a file
+func removeLine(t *testing.T, file string, line int) {
+	ls := lines(t, file)
+	writeLines(t, file, append(ls[:line], ls[line+1:]...))
+}
+
+func writeLines(t *testing.T, file string, ls [][]byte) {
+	data := bytes.Join(ls, nil)
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Head of a given line (from 0) of a file
+func headOf(t *testing.T, file string, line int) *Head {
+	r := &Record{}
+	if err := json.Unmarshal(lines(t, file)[line], r); err != nil {
+		t.Fatal(err)
+	}
+	return &Head{Seq: r.Seq, Hash: r.Hash}
+}
+
+func setHead(t *testing.T, file string, head *Head) {
+	if err := writeHead(HeadFile(file), head); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
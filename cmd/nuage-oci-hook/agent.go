@@ -0,0 +1,131 @@
+package main
+
+////
+//// Nuage agent server client: Mutual TLS (or Unix domain socket) connection and the container / container interface routes
+////
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/OpenPlatformSDN/nuage-cni/agent/types"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/placement"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+type agentClient struct {
+	base      string // E.g. "https://127.0.0.1:7443"
+	client    *http.Client
+	requestID string // Sent as "X-Request-ID", correlating the hook calls in the agent logs and traces
+}
+
+// Client of the agent at a given URL: "https://<host>:<port>" with a client certificate, key and the agent CA certificate, or "unix://<socket path>"
+func newAgentClient(agentURL, certFile, keyFile, caFile string) (*agentClient, error) {
+	u, err := url.Parse(agentURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid agent URL: %s. Error: %s", agentURL, err)
+	}
+
+	switch u.Scheme {
+	case "https":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load hook certificate: %s and private key: %s. Error: %s", certFile, keyFile, err)
+		}
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read agent CA certificate: %s. Error: %s", caFile, err)
+		}
+		cas := x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificate in agent CA certificate file: %s", caFile)
+		}
+
+		transport := &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      cas,
+			MinVersion:   tls.VersionTLS12,
+		}}
+		return &agentClient{base: strings.TrimSuffix(agentURL, "/"), client: &http.Client{Transport: transport}}, nil
+
+	case "unix":
+		// Authorized by the peer credentials of the hook process
+		socket := u.Path
+		transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}}
+		return &agentClient{base: "http://unix", client: &http.Client{Transport: transport}}, nil
+	}
+
+	return nil, fmt.Errorf("Invalid agent URL: %s. Valid URLs are: https://<host>:<port>, unix://<socket path>", agentURL)
+}
+
+// Cache the container metadata and placement. The agent validates them against its VSD configuration
+func (c *agentClient) putContainer(ctx context.Context, creq *placement.Request) error {
+	return c.call(ctx, "PUT", types.ContainerPath+url.PathEscape(creq.Name), creq, false)
+}
+
+// Remove the container interfaces, then the container. Already removed ones are skipped
+func (c *agentClient) deleteContainer(ctx context.Context, name string) error {
+	if err := c.call(ctx, "DELETE", types.ResultPath+url.PathEscape(name), nil, true); err != nil {
+		return err
+	}
+	return c.call(ctx, "DELETE", types.ContainerPath+url.PathEscape(name), nil, true)
+}
+
+////////
+//////// utils
+////////
+
+// Make an agent API call. Errors carry the agent error title and description, if any
+func (c *agentClient) call(ctx context.Context, method, path string, body interface{}, notFoundOK bool) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.requestID != "" {
+		req.Header.Set("X-Request-ID", c.requestID)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot reach the Nuage agent at: %s. Error: %s", c.base, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 || (notFoundOK && resp.StatusCode == http.StatusNotFound) {
+		return nil
+	}
+
+	agenterr := bambou.Error{}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if json.Unmarshal(data, &agenterr) != nil || agenterr.Title == "" {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if agenterr.Description == "" {
+		return fmt.Errorf("%s (%s)", agenterr.Title, resp.Status)
+	}
+	return fmt.Errorf("%s -- %s (%s)", agenterr.Title, agenterr.Description, resp.Status)
+}
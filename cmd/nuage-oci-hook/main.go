@@ -0,0 +1,169 @@
+package main
+
+////
+//// OCI runtime hook for the Nuage agent server: Registers the container metadata with the agent at "prestart", and removes the container at "poststop".
+//// The container network itself is set up by the Nuage CNI plugin, which records the container interfaces with the agent.
+////
+//// Configured in the "hooks" of the OCI bundle "config.json" (or by the CRI-O / podman hooks directory), e.g.:
+////   "prestart": [{"path": "/usr/local/bin/nuage-oci-hook", "args": ["nuage-oci-hook", "-agent", "https://127.0.0.1:7443", "-cert", "...", "-key", "...", "-ca", "...", "prestart"]}]
+////   "poststop": [{"path": "/usr/local/bin/nuage-oci-hook", "args": ["nuage-oci-hook", ..., "poststop"]}]
+////
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/placement"
+)
+
+// Hook stages
+const (
+	StagePrestart = "prestart"
+	StagePoststop = "poststop"
+)
+
+// Exit codes. Any non-zero exit code of a "prestart" hook fails the container start
+const (
+	exitOK      = 0
+	exitFailed  = 1
+	exitInvalid = 2 // Invalid hook invocation
+)
+
+var (
+	agentURL = flag.String("agent", "https://127.0.0.1:7443", "Nuage agent server URL: https://<host>:<port>, or unix://<socket path>")
+	certFile = flag.String("cert", "/etc/nuage-oci-hook/hook.crt", "client certificate of the hook, signed by the agent server CA")
+	keyFile  = flag.String("key", "/etc/nuage-oci-hook/hook.key", "private key of the hook client certificate")
+	caFile   = flag.String("ca", "/etc/nuage-oci-hook/ca.crt", "agent server CA certificate")
+	timeout  = flag.Duration("timeout", 30*time.Second, "deadline of the agent calls")
+
+	// Placement of containers without "nuage.io/*" annotations
+	enterprise = flag.String("enterprise", "", "default Nuage Enterprise of the containers")
+	domain     = flag.String("domain", "", "default Nuage Domain of the containers")
+	zone       = flag.String("zone", "", "default Nuage Zone of the containers")
+	subnet     = flag.String("subnet", "", "default Nuage Subnet of the containers")
+	l2domain   = flag.String("l2domain", "", "default Nuage L2Domain of the containers. Alternative to domain, zone and subnet")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [prestart|poststop] < <OCI container state>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	os.Exit(run())
+}
+
+func run() int {
+	state, err := readState(os.Stdin)
+	if err != nil {
+		return fail(exitInvalid, "%s", err)
+	}
+
+	// The stage is either given, or derived from the container status
+	stage := flag.Arg(0)
+	if stage == "" {
+		switch state.Status {
+		case "created":
+			stage = StagePrestart
+		case "stopped":
+			stage = StagePoststop
+		default:
+			return fail(exitInvalid, "No hook stage given, and none matches container status: %s", state.Status)
+		}
+	}
+
+	spec, err := readSpec(state)
+	if err != nil {
+		// The bundle may be gone at "poststop". The container is then known by its ID only
+		if stage != StagePoststop {
+			return fail(exitFailed, "%s", err)
+		}
+		spec = &ociSpec{Annotations: state.Annotations}
+	}
+
+	name := state.ID
+	if n := spec.Annotations[placement.AnnotationName]; n != "" {
+		name = n
+	}
+
+	agent, err := newAgentClient(*agentURL, *certFile, *keyFile, *caFile)
+	if err != nil {
+		return fail(exitFailed, "%s", err)
+	}
+	agent.requestID = state.ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch stage {
+	case StagePrestart:
+		creq, err := containerRequest(name, state, spec)
+		if err != nil {
+			return fail(exitFailed, "Invalid placement of Container: %s: %s", name, err)
+		}
+		if err := agent.putContainer(ctx, creq); err != nil {
+			return fail(exitFailed, "Container: %s rejected by the Nuage agent: %s", name, err)
+		}
+		fmt.Fprintf(os.Stderr, "nuage-oci-hook: Container: %s registered with the Nuage agent\n", name)
+
+	case StagePoststop:
+		if err := agent.deleteContainer(ctx, name); err != nil {
+			return fail(exitFailed, "Cannot remove Container: %s from the Nuage agent: %s", name, err)
+		}
+
+	default:
+		return fail(exitInvalid, "Invalid hook stage: %s. Valid stages are: %s, %s", stage, StagePrestart, StagePoststop)
+	}
+
+	return exitOK
+}
+
+////////
+//////// utils
+////////
+
+// Container create request: Container metadata plus its placement, from the "nuage.io/*" annotations of the container. Missing fields default to the hook flags
+func containerRequest(name string, state *ociState, spec *ociSpec) (*placement.Request, error) {
+	p, err := placement.FromAnnotations(spec.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = &placement.Placement{}
+	}
+
+	if p.Enterprise == "" {
+		p.Enterprise = *enterprise
+	}
+	// Defaults for an L2Domain and for a Domain are mutually exclusive: An annotated Domain (or L2Domain) decides which ones apply
+	switch {
+	case p.L2Domain == "" && p.Domain == "" && *l2domain != "":
+		p.L2Domain = *l2domain
+	case p.L2Domain == "":
+		if p.Domain == "" {
+			p.Domain = *domain
+		}
+		if p.Zone == "" {
+			p.Zone = *zone
+		}
+		if p.Subnet == "" {
+			p.Subnet = *subnet
+		}
+	}
+
+	creq := &placement.Request{Placement: p}
+	creq.Name = name
+	creq.UUID = state.ID
+	creq.EnterpriseName = p.Enterprise
+	return creq, nil
+}
+
+// Report a failure on stderr -- shown by the runtime when the hook fails -- and return the exit code
+func fail(code int, f string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "nuage-oci-hook: "+f+"\n", args...)
+	return code
+}
@@ -0,0 +1,62 @@
+package main
+
+////
+//// OCI runtime state (hook stdin) and bundle configuration. Only the fields used by the hook
+////
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Container state, as passed by the runtime to the hooks on stdin
+type ociState struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Bundle configuration ("config.json")
+type ociSpec struct {
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func readState(r io.Reader) (*ociState, error) {
+	state := &ociState{}
+	if err := json.NewDecoder(r).Decode(state); err != nil {
+		return nil, fmt.Errorf("Cannot decode the OCI container state on stdin: %s", err)
+	}
+	if state.ID == "" {
+		return nil, fmt.Errorf("No container ID in the OCI container state on stdin")
+	}
+	return state, nil
+}
+
+// Bundle configuration of a container. The annotations of the container state (if any) take precedence over the bundle ones
+func readSpec(state *ociState) (*ociSpec, error) {
+	spec := &ociSpec{}
+
+	f, err := os.Open(filepath.Join(state.Bundle, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read the bundle configuration of container: %s. Error: %s", state.ID, err)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(spec); err != nil {
+		return nil, fmt.Errorf("Cannot decode the bundle configuration of container: %s. Error: %s", state.ID, err)
+	}
+
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	for k, v := range state.Annotations {
+		spec.Annotations[k] = v
+	}
+	return spec, nil
+}
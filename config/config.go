@@ -1,9 +1,17 @@
 package config
 
 import (
+	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 
 	nuagecni "github.com/OpenPlatformSDN/nuage-cni/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -11,30 +19,310 @@ import (
 // nuage-oci-agent server -- configuration file
 type Config struct {
 	// Not supplied in YAML config file
-	ConfigFile string `yaml:"-"`
+	ConfigFile string            `yaml:"-"`
+	Sources    map[string]string `yaml:"-"` // Where the effective value of each field comes from. Key: YAML path. Missing: default
 	// Config file fields
 	Vsd         vsdConfig            `yaml:"vsd-config"`
 	AgentServer nuagecni.AgentConfig `yaml:"agent-config"`
+	Listen      listenConfig         `yaml:"listen-config"`
+	Audit       auditConfig          `yaml:"audit-config"`
+	Lifecycle   lifecycleConfig      `yaml:"lifecycle-config"`
+	Log         logConfig            `yaml:"log-config"`
+	Trace       traceConfig          `yaml:"trace-config"`
+	Debug       debugConfig          `yaml:"debug-config"`
+	Drift       driftConfig          `yaml:"drift-config"`
+	Clients     []ClientConfig       `yaml:"clients"` // Clients allowed to use the agent API. Empty: Any client with a certificate signed by "agent-config.caFile"
 }
 
 type vsdConfig struct {
-	Url        string `yaml:"url"`
-	APIVersion string `yaml:"apiversion"`
+	Url        string         `yaml:"url"`
+	APIVersion string         `yaml:"apiversion"`
+	Enterprise string         `yaml:"enterprise"` // Single tenant configuration. Kept for backwards compatibility
+	Domain     string         `yaml:"domain"`     // Single tenant configuration. Kept for backwards compatibility
+	L2Domain   string         `yaml:"l2domain"`   // Single tenant configuration. Alternative to "domain"
+	Tenants    []TenantConfig `yaml:"tenants"`    // Enterprise / Domain pairs served by this agent
+	CertFile   string         `yaml:"certFile"`
+	KeyFile    string         `yaml:"keyFile"`
+	TLS        TLSConfig      `yaml:"tls"` // TLS policy the VSD endpoint is checked against before each VSD session is established
+}
+
+// Nuage Enterprise and Domain -- or L2Domain -- for OCI containers of a given tenant
+type TenantConfig struct {
 	Enterprise string `yaml:"enterprise"`
 	Domain     string `yaml:"domain"`
-	CertFile   string `yaml:"certFile"`
-	KeyFile    string `yaml:"keyFile"`
+	L2Domain   string `yaml:"l2domain"`
+}
+
+// Agent API listeners, in addition to -- or instead of -- the TLS listener on "agent-config.server-port"
+type listenConfig struct {
+	UnixSocket string    `yaml:"unix-socket"` // Unix domain socket path. Empty: No Unix domain socket listener
+	DisableTCP bool      `yaml:"disable-tcp"` // Only listen on the Unix domain socket
+	TLS        TLSConfig `yaml:"tls"`         // TLS policy of the agent server listener. VSD connection only fields do not apply
+	Health     string    `yaml:"health"`      // Address of a listener serving only the health and readiness checks, e.g. 127.0.0.1:7080. Plain HTTP, unauthenticated. Empty: Disabled
+}
+
+// Audit log of the changes made through the agent API
+type auditConfig struct {
+	File     string `yaml:"file"`      // Audit log file. Empty: No audit log
+	MaxSize  int    `yaml:"max-size"`  // Size of the audit log file before rotation, in MB. Default: 100
+	MaxFiles int    `yaml:"max-files"` // Number of rotated audit log files kept. Default: 10
+	FailOpen bool   `yaml:"fail-open"` // Make changes even if they cannot be recorded in the audit log. Default: Such changes are refused
+}
+
+// Local state and shutdown of the agent
+type lifecycleConfig struct {
+	StateFile       string `yaml:"state-file"`       // Local state (caches, mirrors, VIPs, Redirection Targets) saved at shutdown and restored at startup. Empty: Not saved
+	ShutdownTimeout int    `yaml:"shutdown-timeout"` // Deadline for draining in-flight requests and VSD operations at shutdown, in seconds. Default: 30
+}
+
+// Agent logging
+type logConfig struct {
+	Format string    `yaml:"format"` // text ("glog" log files, default) or json
+	File   string    `yaml:"file"`   // JSON log file. Default: stderr
+	Levels logLevels `yaml:"levels"` // Log level of each subsystem: debug, info (default), warning or error
 }
 
-func LoadConfig(conf *Config) error {
+type logLevels struct {
+	Server    string `yaml:"server"`
+	VSDClient string `yaml:"vsdclient"`
+	Config    string `yaml:"config"`
+}
+
+// Log levels by subsystem name
+func (levels logLevels) Map() map[string]string {
+	return map[string]string{logging.Server: levels.Server, logging.VSDClient: levels.VSDClient, logging.Config: levels.Config}
+}
+
+// Request tracing: Spans of the agent API calls and of the resulting VSD calls
+type traceConfig struct {
+	Exporter string `yaml:"exporter"` // Span exporter: stdout or file. Empty: No tracing
+	File     string `yaml:"file"`     // Span file of the "file" exporter
+}
+
+// Debugging aids
+type debugConfig struct {
+	PprofListen string `yaml:"pprof-listen"` // Address of the admin listener serving "net/http/pprof", e.g. 127.0.0.1:6060. Plain HTTP, unauthenticated. Empty: Disabled
+}
+
+// Periodic audit of the container interface records against the VSD
+type driftConfig struct {
+	Interval int  `yaml:"interval"` // Time between audits, in seconds. 0 (default): No periodic audit
+	Repair   bool `yaml:"repair"`   // Repair the local records diverging from the VSD. Default: Report only
+}
+
+// Default deadline for draining in-flight requests and VSD operations at shutdown, in seconds
+const DefaultShutdownTimeout = 30
+
+// Agent API client, identified by its certificate, and what it is allowed to do
+type ClientConfig struct {
+	Identity    string   `yaml:"identity"`    // Client certificate Subject DN or Common Name, or one of its DNS / email / URI SANs. Unix domain socket clients: "uid:<UID>" or "gid:<GID>" -- never matched against certificates
+	Role        string   `yaml:"role"`        // read-only, container-writer, network-admin or admin. Default: admin
+	Enterprises []string `yaml:"enterprises"` // Enterprises of the containers the client may change. Empty: Any
+	Zones       []string `yaml:"zones"`       // Zones of the containers the client may change. Empty: Any
+}
+
+// Agent API client roles
+const (
+	RoleReadOnly        = "read-only"        // Read any resource
+	RoleContainerWriter = "container-writer" // Read any resource. Change containers, container interfaces and IP reservations
+	RoleNetworkAdmin    = "network-admin"    // Read any resource. Change CNI networks, mirrors, VIPs and redirection targets
+	RoleAdmin           = "admin"            // Read and change any resource
+)
+
+// All the Enterprise / Domain pairs in the configuration: The single tenant one (if any), followed by the "tenants" list
+func (vsd *vsdConfig) AllTenants() []TenantConfig {
+	var tenants []TenantConfig
+	if vsd.Enterprise != "" || vsd.Domain != "" || vsd.L2Domain != "" {
+		tenants = append(tenants, TenantConfig{Enterprise: vsd.Enterprise, Domain: vsd.Domain, L2Domain: vsd.L2Domain})
+	}
+	return append(tenants, vsd.Tenants...)
+}
+
+// Configuration sources, in increasing order of precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Prefix of the environment variables overriding configuration file fields. E.g. "vsd-config.url" <-> NUAGE_OCI_VSD_CONFIG_URL
+const EnvPrefix = "NUAGE_OCI_"
+
+// Load the configuration in layers: defaults (flag defaults) < configuration file < environment < explicitly given flags
+// XXX - The configuration file is optional, unless explicitly given
+func LoadConfig(conf *Config, flagSet *flag.FlagSet) error {
+	conf.Sources = make(map[string]string)
+
+	// Explicit flags. Captured before the lower layers overwrite the fields they are bound to
+	explicit := make(map[string]string)
+	flagSet.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	//// Configuration file
 	data, err := ioutil.ReadFile(conf.ConfigFile)
 	if err != nil {
-		return err
+		if _, given := explicit["config"]; given || !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		if err := yaml.Unmarshal(data, conf); err != nil {
+			return err
+		}
+
+		var node interface{}
+		yaml.Unmarshal(data, &node)
+		markSources(node, "", SourceFile, conf.Sources)
+	}
+
+	//// Environment
+	for _, f := range fields(reflect.ValueOf(conf).Elem(), "") {
+		value, exists := os.LookupEnv(envName(f.path))
+		if !exists {
+			continue
+		}
+		if err := setField(f.value, value); err != nil {
+			return fmt.Errorf("Invalid value of environment variable %s: %s", envName(f.path), err)
+		}
+		conf.Sources[f.path] = SourceEnv
 	}
 
-	if err := yaml.Unmarshal(data, conf); err != nil {
-		return err
+	//// Explicit flags
+	for name, value := range explicit {
+		flagSet.Set(name, value)
+		if path, exists := flagPaths[name]; exists {
+			conf.Sources[path] = SourceFlag
+		}
 	}
 
 	return nil
 }
+
+// Load the configuration again, into a new Config, with the same explicitly given flags as the current one
+func Reload(flagSet *flag.FlagSet) (*Config, error) {
+	conf := new(Config)
+
+	reloadFlags := flag.NewFlagSet("reload", flag.ContinueOnError)
+	Flags(conf, reloadFlags)
+	flagSet.Visit(func(f *flag.Flag) {
+		if reloadFlags.Lookup(f.Name) != nil {
+			reloadFlags.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if err := LoadConfig(conf, reloadFlags); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// YAML paths of the fields that differ between two configurations
+func Diff(old, new *Config) []string {
+	var changed []string
+
+	newFields := fields(reflect.ValueOf(new).Elem(), "")
+	for i, f := range fields(reflect.ValueOf(old).Elem(), "") {
+		if !reflect.DeepEqual(f.value.Interface(), newFields[i].value.Interface()) {
+			changed = append(changed, f.path)
+		}
+	}
+
+	return changed
+}
+
+// Print the effective value of every configuration field and its source. Secrets are redacted
+func PrintConfig(conf *Config, w io.Writer) {
+	for _, f := range fields(reflect.ValueOf(conf).Elem(), "") {
+		source, exists := conf.Sources[f.path]
+		if !exists {
+			source = SourceDefault
+		}
+
+		value := fmt.Sprintf("%v", f.value.Interface())
+		if secretField(f.path) && value != "" {
+			value = "<redacted>"
+		}
+
+		fmt.Fprintf(w, "%s = %s (%s, %s)\n", f.path, value, source, envName(f.path))
+	}
+}
+
+////////
+//////// utils
+////////
+
+// A configuration file field: YAML path and (settable) value
+type field struct {
+	path  string
+	value reflect.Value
+}
+
+// All the configuration file fields of a (struct) value, by YAML path. Nested structs are flattened, any other type (incl. lists) is a single field
+func fields(v reflect.Value, prefix string) []field {
+	var fl []field
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		if v.Field(i).Kind() == reflect.Struct {
+			fl = append(fl, fields(v.Field(i), prefix+tag+".")...)
+		} else {
+			fl = append(fl, field{path: prefix + tag, value: v.Field(i)})
+		}
+	}
+	return fl
+}
+
+// Set the YAML paths present in a configuration file node to the given source
+func markSources(node interface{}, prefix, source string, sources map[string]string) {
+	m, ok := node.(map[interface{}]interface{})
+	if !ok {
+		sources[strings.TrimSuffix(prefix, ".")] = source
+		return
+	}
+
+	for k, v := range m {
+		markSources(v, fmt.Sprintf("%s%v.", prefix, k), source, sources)
+	}
+}
+
+func setField(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	default: // Lists and other complex types are only supported in the configuration file
+		return yaml.Unmarshal([]byte(value), v.Addr().Interface())
+	}
+	return nil
+}
+
+func envName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+}
+
+// Fields whose values are not to be displayed. XXX - Certificate and key fields hold file paths, not secrets
+func secretField(path string) bool {
+	name := strings.ToLower(path[strings.LastIndex(path, ".")+1:])
+	for _, secret := range []string{"password", "passphrase", "secret", "token"} {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+	return false
+}
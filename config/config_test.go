@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigLayering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(file, []byte("vsd-config:\n  url: https://file:8443\n  apiversion: v4_0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		file       bool
+		env        string
+		flags      []string
+		url        string
+		urlSource  string
+		apiversion string
+	}{
+		{name: "defaults", url: "", urlSource: SourceDefault, apiversion: "v5_0"},
+		{name: "file over defaults", file: true, url: "https://file:8443", urlSource: SourceFile, apiversion: "v4_0"},
+		{name: "env over file", file: true, env: "https://env:8443", url: "https://env:8443", urlSource: SourceEnv, apiversion: "v4_0"},
+		{name: "env over defaults", env: "https://env:8443", url: "https://env:8443", urlSource: SourceEnv, apiversion: "v5_0"},
+		{name: "flag over env", file: true, env: "https://env:8443", flags: []string{"-vsdurl", "https://flag:8443"}, url: "https://flag:8443", urlSource: SourceFlag, apiversion: "v4_0"},
+		{name: "flag over file", file: true, flags: []string{"-vsdurl", "https://flag:8443"}, url: "https://flag:8443", urlSource: SourceFlag, apiversion: "v4_0"},
+		{name: "flag at its default value", file: true, flags: []string{"-vsdapiversion", "v5_0"}, url: "https://file:8443", urlSource: SourceFile, apiversion: "v5_0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.env != "" {
+				os.Setenv(envName("vsd-config.url"), test.env)
+				defer os.Unsetenv(envName("vsd-config.url"))
+			}
+
+			configFile := filepath.Join(dir, "missing.yaml")
+			if test.file {
+				configFile = file
+			}
+
+			conf := new(Config)
+			flagSet := flag.NewFlagSet(test.name, flag.ContinueOnError)
+			Flags(conf, flagSet)
+			if err := flagSet.Parse(append([]string{"-config", configFile}, test.flags...)); err != nil {
+				t.Fatal(err)
+			}
+
+			err := LoadConfig(conf, flagSet)
+			switch {
+			case !test.file && err == nil:
+				t.Fatalf("LoadConfig: no error for an explicitly given missing configuration file")
+			case !test.file:
+				return
+			case err != nil:
+				t.Fatalf("LoadConfig: %s", err)
+			}
+
+			if conf.Vsd.Url != test.url {
+				t.Errorf("vsd-config.url = %q, want %q", conf.Vsd.Url, test.url)
+			}
+			if source := sourceOf(conf, "vsd-config.url"); source != test.urlSource {
+				t.Errorf("vsd-config.url source = %q, want %q", source, test.urlSource)
+			}
+			if conf.Vsd.APIVersion != test.apiversion {
+				t.Errorf("vsd-config.apiversion = %q, want %q", conf.Vsd.APIVersion, test.apiversion)
+			}
+		})
+	}
+}
+
+func TestLoadConfigOptionalFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantErr   bool
+		check     func(conf *Config) bool
+		checkDesc string
+	}{
+		{name: "no file", check: func(conf *Config) bool { return conf.Vsd.APIVersion == "v5_0" }, checkDesc: "default API version"},
+		{name: "bool env", env: map[string]string{"NUAGE_OCI_LISTEN_CONFIG_DISABLE_TCP": "true"}, check: func(conf *Config) bool { return conf.Listen.DisableTCP }, checkDesc: "TCP listener disabled"},
+		{name: "int env", env: map[string]string{"NUAGE_OCI_AUDIT_CONFIG_MAX_SIZE": "5"}, check: func(conf *Config) bool { return conf.Audit.MaxSize == 5 }, checkDesc: "audit max size 5"},
+		{name: "invalid bool env", env: map[string]string{"NUAGE_OCI_LISTEN_CONFIG_DISABLE_TCP": "maybe"}, wantErr: true},
+		{name: "invalid int env", env: map[string]string{"NUAGE_OCI_AUDIT_CONFIG_MAX_SIZE": "five"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				os.Setenv(name, value)
+				defer os.Unsetenv(name)
+			}
+
+			// The default configuration file does not exist in the test working directory
+			conf := new(Config)
+			flagSet := flag.NewFlagSet(test.name, flag.ContinueOnError)
+			Flags(conf, flagSet)
+
+			err := LoadConfig(conf, flagSet)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("LoadConfig: no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %s", err)
+			}
+			if !test.check(conf) {
+				t.Errorf("LoadConfig: want %s", test.checkDesc)
+			}
+		})
+	}
+}
+
+func TestSecretField(t *testing.T) {
+	tests := []struct {
+		path   string
+		secret bool
+	}{
+		{"vsd-config.password", true},
+		{"vsd-config.tls.keyPassphrase", true},
+		{"trace-config.api-token", true},
+		{"clients.secret", true},
+		{"vsd-config.url", false},
+		{"vsd-config.keyFile", false},
+		{"agent-config.certcaFile", false},
+	}
+
+	for _, test := range tests {
+		if secret := secretField(test.path); secret != test.secret {
+			t.Errorf("secretField(%q) = %v, want %v", test.path, secret, test.secret)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	conf := new(Config)
+	flagSet := flag.NewFlagSet("print", flag.ContinueOnError)
+	Flags(conf, flagSet)
+	if err := flagSet.Parse([]string{"-vsdurl", "https://flag:8443"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(conf, flagSet); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	PrintConfig(conf, &out)
+
+	tests := []string{
+		"vsd-config.url = https://flag:8443 (flag, NUAGE_OCI_VSD_CONFIG_URL)\n",
+		"vsd-config.apiversion = v5_0 (default, NUAGE_OCI_VSD_CONFIG_APIVERSION)\n",
+		"vsd-config.keyFile = ./nuage-oci-agent-server.key (default, NUAGE_OCI_VSD_CONFIG_KEYFILE)\n",
+	}
+	for _, line := range tests {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("PrintConfig: missing %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+////////
+//////// utils
+////////
+
+func sourceOf(conf *Config, path string) string {
+	if source, exists := conf.Sources[path]; exists {
+		return source
+	}
+	return SourceDefault
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"flag"
+)
+
+// Configuration file fields available as CLI flags
+// Key: Flag name. Value: YAML path of the configuration file field (see "LoadConfig")
+var flagPaths = make(map[string]string)
+
+func Flags(conf *Config, flagSet *flag.FlagSet) {
+
+	flagSet.StringVar(&conf.ConfigFile, "config",
+		"./nuage-oci-agent-config.yaml", "configuration file for Nuage OCI agent server. Precedence: defaults < configuration file < NUAGE_OCI_* environment variables < explicit flags")
+
+	// VSD flags
+	stringFlag(flagSet, &conf.Vsd.Url, "vsdurl", "vsd-config.url",
+		"", "Nuage VSD URL")
+	stringFlag(flagSet, &conf.Vsd.APIVersion, "vsdapiversion", "vsd-config.apiversion",
+		"v5_0", "Nuage VSP API Version")
+	stringFlag(flagSet, &conf.Vsd.Enterprise, "vsdenterprise", "vsd-config.enterprise",
+		"", "Nuage Enterprise Name for OCI containers")
+	stringFlag(flagSet, &conf.Vsd.Domain, "vsddomain", "vsd-config.domain",
+		"", "Nuage Domain Name for OCI containers")
+	stringFlag(flagSet, &conf.Vsd.L2Domain, "vsdl2domain", "vsd-config.l2domain",
+		"", "Nuage L2Domain Name for OCI containers (alternative to a Domain)")
+	stringFlag(flagSet, &conf.Vsd.CertFile, "vsdcertfile", "vsd-config.certFile",
+		"./nuage-oci-agent-server.crt", "VSD login certificate file")
+	stringFlag(flagSet, &conf.Vsd.KeyFile, "vsdkeyfile", "vsd-config.keyFile",
+		"./nuage-oci-agent-server.key", "VSD login private key file")
+
+	// Agent Server flags
+	stringFlag(flagSet, &conf.AgentServer.ServerPort, "serverport", "agent-config.server-port",
+		"7443", "Server port")
+
+	stringFlag(flagSet, &conf.AgentServer.CaFile, "cafile", "agent-config.caFile",
+		"/opt/nuage/etc/ca.crt", "Server CA certificate")
+
+	stringFlag(flagSet, &conf.AgentServer.CertCaFile, "certcafile", "agent-config.certcaFile",
+		"/opt/nuage/etc/agent-server.pem", "Server certificate (server + CA certificates PEM file)")
+
+	stringFlag(flagSet, &conf.AgentServer.KeyFile, "keyfile", "agent-config.keyFile",
+		"/opt/nuage/etc/agent-server.key", "Server private key file")
+}
+
+func stringFlag(flagSet *flag.FlagSet, p *string, name, path, value, usage string) {
+	flagSet.StringVar(p, name, value, usage)
+	flagPaths[name] = path
+}
@@ -0,0 +1,91 @@
+package config
+
+////
+//// TLS policy of the agent server listener and of the VSD connection
+////
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLS policy of a connection. Empty values use the Go defaults, except for the minimum version (TLS 1.2)
+type TLSConfig struct {
+	MinVersion   string   `yaml:"min-version"`   // 1.0, 1.1, 1.2 or 1.3
+	MaxVersion   string   `yaml:"max-version"`   // 1.0, 1.1, 1.2 or 1.3
+	CipherSuites []string `yaml:"cipher-suites"` // Go cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Not applicable to TLS 1.3
+	Curves       []string `yaml:"curves"`        // X25519, P256, P384 or P521, in order of preference
+	// VSD connection only
+	CAFile      string `yaml:"caFile"`      // CA bundle the VSD certificate must chain to. Default: System root CAs
+	Fingerprint string `yaml:"fingerprint"` // SHA-256 fingerprint of the VSD certificate (hex, with or without colons). Replaces the CA verification
+	Insecure    bool   `yaml:"insecure"`    // Do not verify the VSD certificate. For labs ONLY
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// Set the TLS versions, cipher suites and curves of the policy in a "tls.Config"
+func (policy *TLSConfig) Apply(tc *tls.Config) error {
+	tc.MinVersion = tls.VersionTLS12
+	if policy.MinVersion != "" {
+		v, valid := tlsVersions[policy.MinVersion]
+		if !valid {
+			return fmt.Errorf("Invalid TLS minimum version: %s. Valid versions are: 1.0, 1.1, 1.2, 1.3", policy.MinVersion)
+		}
+		tc.MinVersion = v
+	}
+
+	if policy.MaxVersion != "" {
+		v, valid := tlsVersions[policy.MaxVersion]
+		if !valid {
+			return fmt.Errorf("Invalid TLS maximum version: %s. Valid versions are: 1.0, 1.1, 1.2, 1.3", policy.MaxVersion)
+		}
+		tc.MaxVersion = v
+	}
+
+	if tc.MaxVersion != 0 && tc.MaxVersion < tc.MinVersion {
+		return fmt.Errorf("TLS maximum version: %s is lower than the minimum version", policy.MaxVersion)
+	}
+
+	suites := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[cs.Name] = cs.ID
+	}
+
+	tc.CipherSuites = nil
+	for _, name := range policy.CipherSuites {
+		id, valid := suites[name]
+		if !valid {
+			return fmt.Errorf("Invalid TLS cipher suite: %s", name)
+		}
+		tc.CipherSuites = append(tc.CipherSuites, id)
+	}
+
+	tc.CurvePreferences = nil
+	for _, name := range policy.Curves {
+		id, valid := tlsCurves[name]
+		if !valid {
+			return fmt.Errorf("Invalid TLS curve: %s. Valid curves are: X25519, P256, P384, P521", name)
+		}
+		tc.CurvePreferences = append(tc.CurvePreferences, id)
+	}
+
+	return nil
+}
+
+// Pinned certificate fingerprint, normalized to lower case hex without colons. Empty if none
+func (policy *TLSConfig) PinnedFingerprint() string {
+	return strings.ToLower(strings.Replace(policy.Fingerprint, ":", "", -1))
+}
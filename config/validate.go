@@ -0,0 +1,343 @@
+package config
+
+////
+//// Preflight checks of the configuration file and of the files it refers to
+////
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Outcome of a single preflight check. A nil "Err" means the check passed
+type Check struct {
+	Name string
+	Err  error
+}
+
+// Check the configuration file: YAML schema, mandatory fields, and readability and validity of all certificates and keys
+func Validate(conf *Config) []Check {
+	var checks []Check
+
+	// XXX - The configuration file is optional (see "LoadConfig")
+	if data, err := ioutil.ReadFile(conf.ConfigFile); err == nil {
+		checks = append(checks, Check{Name: "Configuration file readable: " + conf.ConfigFile})
+		checks = append(checks, Check{Name: "Configuration file schema", Err: checkSchema(data)})
+	} else if !os.IsNotExist(err) {
+		checks = append(checks, Check{Name: "Configuration file readable: " + conf.ConfigFile, Err: err})
+		return checks
+	}
+
+	checks = append(checks, Check{Name: "Mandatory configuration fields", Err: checkMandatory(conf)})
+	checks = append(checks, Check{Name: "Agent API clients", Err: checkClients(conf)})
+	checks = append(checks, Check{Name: "Agent API listeners", Err: checkListeners(conf)})
+	checks = append(checks, Check{Name: "Agent shutdown", Err: checkLifecycle(conf)})
+	checks = append(checks, Check{Name: "Agent logging", Err: checkLogging(conf)})
+	checks = append(checks, Check{Name: "Request tracing", Err: checkTracing(conf)})
+	checks = append(checks, Check{Name: "Debug listener", Err: checkDebug(conf)})
+	checks = append(checks, Check{Name: "Drift audit", Err: checkDrift(conf)})
+	checks = append(checks, Check{Name: "Agent server TLS policy", Err: conf.Listen.TLS.Apply(&tls.Config{})})
+	checks = append(checks, Check{Name: "VSD TLS policy", Err: checkVSDTLS(&conf.Vsd.TLS)})
+
+	// VSD login certificate
+	checks = append(checks, checkKeyPair("VSD login", conf.Vsd.CertFile, conf.Vsd.KeyFile)...)
+
+	// Agent server certificate, chaining to the CA certificate
+	checks = append(checks, checkKeyPair("Agent server", conf.AgentServer.CertCaFile, conf.AgentServer.KeyFile)...)
+	checks = append(checks, Check{Name: "Agent server certificate chains to CA certificate: " + conf.AgentServer.CaFile, Err: checkChain(conf.AgentServer.CertCaFile, conf.AgentServer.CaFile)})
+
+	return checks
+}
+
+////////
+//////// utils
+////////
+
+// Check the YAML keys in the configuration file are all known
+func checkSchema(data []byte) error {
+	var node interface{}
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+
+	if unknown := unknownKeys(node, reflect.TypeOf(Config{}), ""); len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown configuration fields: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// Keys in a YAML node that do not match the YAML tags of the given type
+func unknownKeys(node interface{}, t reflect.Type, path string) []string {
+	var unknown []string
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]; tag != "" && tag != "-" {
+				fields[tag] = t.Field(i).Type
+			}
+		}
+
+		for k, v := range m {
+			key := fmt.Sprintf("%v", k)
+			if ft, exists := fields[key]; exists {
+				unknown = append(unknown, unknownKeys(v, ft, path+key+".")...)
+			} else {
+				unknown = append(unknown, path+key)
+			}
+		}
+
+	case reflect.Slice:
+		if l, ok := node.([]interface{}); ok {
+			for i, v := range l {
+				unknown = append(unknown, unknownKeys(v, t.Elem(), fmt.Sprintf("%s%d.", path, i))...)
+			}
+		}
+	}
+
+	return unknown
+}
+
+func checkMandatory(conf *Config) error {
+	var missing []string
+	for name, value := range map[string]string{
+		"vsd-config.url":           conf.Vsd.Url,
+		"vsd-config.certFile":      conf.Vsd.CertFile,
+		"vsd-config.keyFile":       conf.Vsd.KeyFile,
+		"agent-config.server-port": conf.AgentServer.ServerPort,
+		"agent-config.caFile":      conf.AgentServer.CaFile,
+		"agent-config.certcaFile":  conf.AgentServer.CertCaFile,
+		"agent-config.keyFile":     conf.AgentServer.KeyFile,
+	} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(conf.Vsd.AllTenants()) == 0 {
+		missing = append(missing, "vsd-config.enterprise / domain / tenants")
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Missing configuration fields: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Check each client has an identity and a valid role
+func checkClients(conf *Config) error {
+	for _, client := range conf.Clients {
+		if client.Identity == "" {
+			return fmt.Errorf("Client without identity: %#v", client)
+		}
+		switch client.Role {
+		case "", RoleReadOnly, RoleContainerWriter, RoleNetworkAdmin, RoleAdmin:
+		default:
+			return fmt.Errorf("Invalid role: %s of client: %s. Valid roles are: %s, %s, %s, %s", client.Role, client.Identity, RoleReadOnly, RoleContainerWriter, RoleNetworkAdmin, RoleAdmin)
+		}
+	}
+	return nil
+}
+
+func checkListeners(conf *Config) error {
+	if conf.Listen.DisableTCP && conf.Listen.UnixSocket == "" {
+		return fmt.Errorf("TCP listener disabled without a Unix domain socket listener (listen-config.unix-socket)")
+	}
+	if conf.Listen.Health != "" {
+		if _, _, err := net.SplitHostPort(conf.Listen.Health); err != nil {
+			return fmt.Errorf("Invalid health listener address: %s (listen-config.health). %s", conf.Listen.Health, err)
+		}
+	}
+	return nil
+}
+
+func checkLifecycle(conf *Config) error {
+	if conf.Lifecycle.ShutdownTimeout < 0 {
+		return fmt.Errorf("Invalid shutdown timeout: %d (lifecycle-config.shutdown-timeout)", conf.Lifecycle.ShutdownTimeout)
+	}
+	return nil
+}
+
+func checkLogging(conf *Config) error {
+	switch conf.Log.Format {
+	case "", logging.FormatText, logging.FormatJSON:
+	default:
+		return fmt.Errorf("Invalid log format: %s. Valid formats are: text, json", conf.Log.Format)
+	}
+
+	for subsystem, level := range conf.Log.Levels.Map() {
+		if level == "" {
+			continue
+		}
+		if _, err := logging.ParseLevel(level); err != nil {
+			return fmt.Errorf("Subsystem: %s. %s", subsystem, err)
+		}
+	}
+	return nil
+}
+
+func checkTracing(conf *Config) error {
+	switch conf.Trace.Exporter {
+	case trace.ExporterNone, trace.ExporterStdout:
+	case trace.ExporterFile:
+		if conf.Trace.File == "" {
+			return fmt.Errorf("No span file for the file span exporter (trace-config.file)")
+		}
+	default:
+		return fmt.Errorf("Invalid span exporter: %s. Valid exporters are: stdout, file", conf.Trace.Exporter)
+	}
+	return nil
+}
+
+func checkDebug(conf *Config) error {
+	if conf.Debug.PprofListen == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(conf.Debug.PprofListen); err != nil {
+		return fmt.Errorf("Invalid pprof listener address: %s (debug-config.pprof-listen). %s", conf.Debug.PprofListen, err)
+	}
+	return nil
+}
+
+func checkDrift(conf *Config) error {
+	if conf.Drift.Interval < 0 {
+		return fmt.Errorf("Invalid drift audit interval: %d (drift-config.interval)", conf.Drift.Interval)
+	}
+	return nil
+}
+
+func checkVSDTLS(policy *TLSConfig) error {
+	if err := policy.Apply(&tls.Config{}); err != nil {
+		return err
+	}
+
+	if fp := policy.PinnedFingerprint(); fp != "" {
+		if _, err := hex.DecodeString(fp); err != nil || len(fp) != 64 {
+			return fmt.Errorf("Invalid SHA-256 fingerprint: %s", policy.Fingerprint)
+		}
+	}
+
+	if policy.CAFile != "" {
+		if _, err := readCerts(policy.CAFile); err != nil {
+			return err
+		}
+	}
+
+	if policy.Insecure {
+		return fmt.Errorf("VSD certificate verification is disabled (insecure mode). Use for labs only")
+	}
+
+	return nil
+}
+
+// Check a certificate and its private key are readable, match, and the certificate is currently valid
+func checkKeyPair(name, certFile, keyFile string) []Check {
+	var checks []Check
+
+	_, err := ioutil.ReadFile(certFile)
+	checks = append(checks, Check{Name: name + " certificate file readable: " + certFile, Err: err})
+	_, err = ioutil.ReadFile(keyFile)
+	checks = append(checks, Check{Name: name + " private key file readable: " + keyFile, Err: err})
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	checks = append(checks, Check{Name: name + " certificate and private key match", Err: err})
+	if err != nil {
+		return checks
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err == nil {
+		err = checkExpiry(cert)
+	}
+	checks = append(checks, Check{Name: name + " certificate validity period", Err: err})
+
+	return checks
+}
+
+func checkExpiry(cert *x509.Certificate) error {
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("Certificate: %s is not valid before: %s", cert.Subject.CommonName, cert.NotBefore)
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("Certificate: %s expired on: %s", cert.Subject.CommonName, cert.NotAfter)
+	}
+	return nil
+}
+
+// Check the (first) certificate in a PEM file chains to the CA certificate(s) in caFile. Any further certificates in the PEM file are used as intermediates
+func checkChain(certFile, caFile string) error {
+	certs, err := readCerts(certFile)
+	if err != nil {
+		return err
+	}
+
+	cas, err := readCerts(caFile)
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+	for _, ca := range cas {
+		roots.AddCert(ca)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return err
+}
+
+// All the certificates in a PEM file
+func readCerts(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No certificates found in: %s", file)
+	}
+
+	return certs, nil
+}
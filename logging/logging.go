@@ -0,0 +1,285 @@
+package logging
+
+////
+//// Leveled logging per subsystem, either as "glog" text (default) or as JSON lines with structured fields
+////
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Subsystems with independently configurable log levels
+const (
+	Server    = "server"
+	VSDClient = "vsdclient"
+	Config    = "config"
+)
+
+var Subsystems = []string{Server, VSDClient, Config}
+
+// Log output formats
+const (
+	FormatText = "text" // Through "glog"
+	FormatJSON = "json"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarningLevel
+	ErrorLevel
+	FatalLevel
+)
+
+var levelNames = []string{"debug", "info", "warning", "error", "fatal"}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames[:FatalLevel] {
+		if strings.ToLower(name) == n {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("Invalid log level: %s. Valid levels are: debug, info, warning, error", name)
+}
+
+var (
+	levels           = map[string]Level{Server: InfoLevel, VSDClient: InfoLevel, Config: InfoLevel}
+	format           = FormatText
+	output io.Writer = os.Stderr
+
+	loggingmutex sync.Mutex
+)
+
+// Set the output format, JSON output file (empty: stderr) and levels by subsystem (empty: info)
+func Configure(logformat, file string, sublevels map[string]string) error {
+	newlevels := make(map[string]Level)
+	for _, subsystem := range Subsystems {
+		newlevels[subsystem] = InfoLevel
+		if name := sublevels[subsystem]; name != "" {
+			level, err := ParseLevel(name)
+			if err != nil {
+				return fmt.Errorf("Subsystem: %s. %s", subsystem, err)
+			}
+			newlevels[subsystem] = level
+		}
+	}
+
+	var out io.Writer = os.Stderr
+	switch logformat {
+	case "", FormatText:
+		logformat = FormatText
+	case FormatJSON:
+		if file != "" {
+			os.MkdirAll(filepath.Dir(file), 0755)
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+			if err != nil {
+				return err
+			}
+			out = f
+		}
+	default:
+		return fmt.Errorf("Invalid log format: %s. Valid formats are: text, json", logformat)
+	}
+
+	loggingmutex.Lock()
+	defer loggingmutex.Unlock()
+
+	// XXX - Any previous JSON log file is left open, as concurrent loggers may still hold it
+	format, output, levels = logformat, out, newlevels
+	return nil
+}
+
+// Current log levels, by subsystem
+func Levels() map[string]string {
+	loggingmutex.Lock()
+	defer loggingmutex.Unlock()
+
+	current := make(map[string]string)
+	for subsystem, level := range levels {
+		current[subsystem] = level.String()
+	}
+	return current
+}
+
+// Change the log level of a given subsystem at runtime
+func SetLevel(subsystem, name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+
+	loggingmutex.Lock()
+	defer loggingmutex.Unlock()
+
+	if _, valid := levels[subsystem]; !valid {
+		return fmt.Errorf("Invalid log subsystem: %s. Valid subsystems are: %s", subsystem, strings.Join(Subsystems, ", "))
+	}
+	levels[subsystem] = level
+	return nil
+}
+
+////////
+//////// Loggers
+////////
+
+// Logger of a subsystem, with optional structured fields
+type Logger struct {
+	subsystem string
+	fields    []interface{} // Key / value pairs
+}
+
+func For(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// Logger with additional fields, given as key / value pairs. E.g. log.With("container", name, "zone", zone)
+func (l *Logger) With(kv ...interface{}) *Logger {
+	return &Logger{subsystem: l.subsystem, fields: append(append([]interface{}(nil), l.fields...), kv...)}
+}
+
+// Whether messages of a given level are logged
+func (l *Logger) Enabled(level Level) bool {
+	loggingmutex.Lock()
+	defer loggingmutex.Unlock()
+	return level >= levels[l.subsystem]
+}
+
+func (l *Logger) Debugf(f string, args ...interface{}) { l.log(DebugLevel, fmt.Sprintf(f, args...)) }
+func (l *Logger) Infof(f string, args ...interface{})  { l.log(InfoLevel, fmt.Sprintf(f, args...)) }
+func (l *Logger) Warningf(f string, args ...interface{}) {
+	l.log(WarningLevel, fmt.Sprintf(f, args...))
+}
+func (l *Logger) Errorf(f string, args ...interface{}) { l.log(ErrorLevel, fmt.Sprintf(f, args...)) }
+func (l *Logger) Fatalf(f string, args ...interface{}) { l.log(FatalLevel, fmt.Sprintf(f, args...)) }
+func (l *Logger) Info(args ...interface{})             { l.log(InfoLevel, fmt.Sprint(args...)) }
+func (l *Logger) Warning(args ...interface{})          { l.log(WarningLevel, fmt.Sprint(args...)) }
+func (l *Logger) Error(args ...interface{})            { l.log(ErrorLevel, fmt.Sprint(args...)) }
+
+////////
+//////// utils
+////////
+
+// XXX - Must be called straight from the exported methods, for the caller file and line to be right
+func (l *Logger) log(level Level, msg string) {
+	loggingmutex.Lock()
+	enabled, logformat, out := level >= levels[l.subsystem], format, output
+	loggingmutex.Unlock()
+
+	if !enabled && level != FatalLevel {
+		return
+	}
+
+	if logformat == FormatJSON {
+		writeJSON(out, level, l.subsystem, msg, l.fields)
+		if level == FatalLevel {
+			glog.Flush()
+			os.Exit(255)
+		}
+		return
+	}
+
+	if len(l.fields) > 0 {
+		msg += " --" + textFields(l.fields)
+	}
+
+	const depth = 2
+	switch level {
+	case DebugLevel:
+		glog.InfoDepth(depth, "DEBUG: "+msg)
+	case InfoLevel:
+		glog.InfoDepth(depth, msg)
+	case WarningLevel:
+		glog.WarningDepth(depth, msg)
+	case ErrorLevel:
+		glog.ErrorDepth(depth, msg)
+	case FatalLevel:
+		glog.FatalDepth(depth, msg)
+	}
+}
+
+var jsonmutex sync.Mutex
+
+func writeJSON(out io.Writer, level Level, subsystem, msg string, fields []interface{}) {
+	record := map[string]interface{}{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		record[fmt.Sprint(fields[i])] = jsonValue(fields[i+1])
+	}
+
+	var buf bytes.Buffer
+	// Fixed fields first, then the structured fields sorted by key
+	buf.WriteString(`{"time":`)
+	writeValue(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeValue(&buf, level.String())
+	buf.WriteString(`,"subsystem":`)
+	writeValue(&buf, subsystem)
+	if _, file, line, ok := runtime.Caller(3); ok {
+		buf.WriteString(`,"caller":`)
+		writeValue(&buf, fmt.Sprintf("%s:%d", filepath.Base(file), line))
+	}
+	buf.WriteString(`,"msg":`)
+	writeValue(&buf, msg)
+
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteString(",")
+		writeValue(&buf, k)
+		buf.WriteString(":")
+		writeValue(&buf, record[k])
+	}
+	buf.WriteString("}\n")
+
+	jsonmutex.Lock()
+	out.Write(buf.Bytes())
+	jsonmutex.Unlock()
+}
+
+func writeValue(buf *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// Errors are logged by message
+func jsonValue(v interface{}) interface{} {
+	if err, ok := v.(error); ok {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		return err.Error()
+	}
+	return v
+}
+
+func textFields(fields []interface{}) string {
+	s := ""
+	for i := 0; i+1 < len(fields); i += 2 {
+		s += fmt.Sprintf(" %v: %v", fields[i], fields[i+1])
+	}
+	return s
+}
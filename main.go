@@ -7,11 +7,12 @@ import (
 	"path"
 	"time"
 
-	"github.com/OpenPlatformSDN/nuage-oci-agent/server"
-
 	"github.com/golang/glog"
 
+	"github.com/OpenPlatformSDN/nuage-oci-agent/audit"
 	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
 
 	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
 )
@@ -23,6 +24,18 @@ var (
 	Config = new(config.Config)
 
 	UseNetPolicies = false
+
+	// Only run the preflight checks of the configuration and VSD prerequisites, then exit
+	Validate = false
+
+	// Only print the effective configuration, then exit
+	PrintConfig = false
+
+	// Only verify the hash chain of a given audit log, then exit
+	VerifyAudit = ""
+
+	// Reload the configuration when the configuration or certificate files change, in addition to SIGHUP
+	Watch = false
 )
 
 ////////
@@ -31,35 +44,19 @@ var (
 
 func init() {
 
-	flag.CommandLine.StringVar(&Config.ConfigFile, "config",
-		"./nuage-oci-agent-config.yaml", "configuration file for Nuage OCI agent server. If this file is specified, all remaining arguments will be ignored")
+	flag.CommandLine.BoolVar(&Validate, "validate",
+		false, "validate the configuration file, certificates and VSD prerequisites, print a pass/fail report and exit")
 
-	// VSD flags
-	flag.CommandLine.StringVar(&Config.Vsd.Url, "vsdurl",
-		"", "Nuage VSD URL")
-	flag.CommandLine.StringVar(&Config.Vsd.APIVersion, "vsdapiversion",
-		"v5_0", "Nuage VSP API Version")
-	flag.CommandLine.StringVar(&Config.Vsd.Enterprise, "vsdenterprise",
-		"", "Nuage Enterprise Name for OCI containers")
-	flag.CommandLine.StringVar(&Config.Vsd.Domain, "vsddomain",
-		"", "Nuage Domain Name for OCI containers")
-	flag.CommandLine.StringVar(&Config.Vsd.CertFile, "vsdcertfile",
-		"./nuage-oci-agent-server.crt", "VSD login certificate file")
-	flag.CommandLine.StringVar(&Config.Vsd.KeyFile, "vsdkeyfile",
-		"./nuage-oci-agent-server.key", "VSD login private key file")
+	flag.CommandLine.BoolVar(&PrintConfig, "print-config",
+		false, "print the effective value and source of every configuration field, then exit")
 
-	// Agent Server flags
-	flag.CommandLine.StringVar(&Config.AgentServer.ServerPort, "serverport",
-		"7443", "Server port")
+	flag.CommandLine.StringVar(&VerifyAudit, "verify-audit",
+		"", "verify the hash chain of the given audit log file (and its rotated files), print the result and exit")
 
-	flag.CommandLine.StringVar(&Config.AgentServer.CaFile, "cafile",
-		"/opt/nuage/etc/ca.crt", "Server CA certificate")
+	flag.CommandLine.BoolVar(&Watch, "watch",
+		false, "reload the configuration when the configuration or certificate files change. The configuration is always reloaded at SIGHUP")
 
-	flag.CommandLine.StringVar(&Config.AgentServer.CertCaFile, "certcafile",
-		"/opt/nuage/etc/agent-server.pem", "Server certificate (server + CA certificates PEM file)")
-
-	flag.CommandLine.StringVar(&Config.AgentServer.KeyFile, "keyfile",
-		"/opt/nuage/etc/agent-server.key", "Server private key file")
+	config.Flags(Config, flag.CommandLine)
 
 	// Set the values for log_dir and logtostderr.  Because this happens before flag.Parse(), cli arguments will override these.
 	// Also set the DefValue parameter so -help shows the new defaults.
@@ -99,25 +96,93 @@ func osExit(context string, err error) {
 
 func main() {
 
-	if len(os.Args) == 1 { // With no arguments, print default usage
-		flag.PrintDefaults()
-		os.Exit(0)
-	}
 	// Flush the logs upon exit
 	defer glog.Flush()
 
+	if Validate {
+		glog.Flush()
+		os.Exit(preflight())
+	}
+
+	if VerifyAudit != "" {
+		glog.Flush()
+		os.Exit(verifyAudit(VerifyAudit))
+	}
+
+	if PrintConfig {
+		if err := config.LoadConfig(Config, flag.CommandLine); err != nil {
+			osExit("Cannot load configuration", err)
+		}
+		config.PrintConfig(Config, os.Stdout)
+		glog.Flush()
+		os.Exit(0)
+	}
+
 	glog.Infof("===> Starting %s...", path.Base(os.Args[0]))
 
-	if err := config.LoadConfig(Config); err != nil {
-		osExit("Cannot read configuration file", err)
+	if err := config.LoadConfig(Config, flag.CommandLine); err != nil {
+		osExit("Cannot load configuration", err)
+	}
+
+	if err := logging.Configure(Config.Log.Format, Config.Log.File, Config.Log.Levels.Map()); err != nil {
+		osExit("Invalid logging configuration", err)
+	}
+
+	if exporter, err := trace.NewExporter(Config.Trace.Exporter, Config.Trace.File); err != nil {
+		osExit("Invalid tracing configuration", err)
+	} else if exporter != nil {
+		trace.SetExporter(exporter)
 	}
 
 	if err := vsdclient.InitClient(Config); err != nil {
 		osExit("VSD client error", err)
 	}
 
-	if err := server.Server(Config.AgentServer); err != nil {
-		osExit("Failed to start OCI agent server", err)
+	go handleReloads()
+
+	code := serve()
+	glog.Flush()
+	os.Exit(code)
+}
+
+// Run the preflight checks and print a pass/fail report. Returns the process exit code: non-zero if any check failed
+func preflight() int {
+	var checks []config.Check
+
+	if err := config.LoadConfig(Config, flag.CommandLine); err != nil {
+		checks = append(checks, config.Check{Name: "Configuration file: " + Config.ConfigFile, Err: err})
+	} else {
+		checks = append(checks, config.Validate(Config)...)
+		checks = append(checks, vsdclient.Preflight(Config)...)
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if check.Err != nil {
+			failed++
+			fmt.Printf("[FAIL] %s: %s\n", check.Name, check.Err)
+		} else {
+			fmt.Printf("[PASS] %s\n", check.Name)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+
+	fmt.Printf("All %d checks passed\n", len(checks))
+	return 0
+}
+
+// Verify the hash chain of an audit log and print the result. Returns the process exit code: non-zero if the audit log was tampered with
+func verifyAudit(file string) int {
+	count, err := audit.Verify(file)
+	if err != nil {
+		fmt.Printf("[FAIL] Audit log: %s. %d record(s) verified before: %s\n", file, count, err)
+		return 1
 	}
 
+	fmt.Printf("[PASS] Audit log: %s. %d record(s) verified\n", file, count)
+	return 0
 }
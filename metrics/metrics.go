@@ -0,0 +1,256 @@
+package metrics
+
+////
+//// Minimal Prometheus metrics: Counters, histograms and gauges, exposed in the Prometheus text format (version 0.0.4)
+////
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefix of all the metric names
+const Namespace = "nuage_oci_agent_"
+
+// Default latency histogram buckets, in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registry      []collector
+	registrymutex sync.Mutex
+)
+
+func register(c collector) {
+	registrymutex.Lock()
+	registry = append(registry, c)
+	registrymutex.Unlock()
+}
+
+// Serve all the registered metrics
+func Handler(w http.ResponseWriter, req *http.Request) {
+	registrymutex.Lock()
+	collectors := append([]collector(nil), registry...)
+	registrymutex.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+////////
+//////// Counters
+////////
+
+// Counters partitioned by label values
+type CounterVec struct {
+	desc
+	values map[string]float64
+	mutex  sync.Mutex
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{Namespace + name, help, labels}, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.mutex.Lock()
+	c.values[key(labelValues)] += v
+	c.mutex.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.header(w, "counter")
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.n, c.labelPairs(k, ""), formatValue(c.values[k]))
+	}
+}
+
+////////
+//////// Histograms
+////////
+
+// Histograms partitioned by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	series  map[string]*histogram
+	mutex   sync.Mutex
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{desc: desc{Namespace + name, help, labels}, buckets: buckets, series: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	k := key(labelValues)
+	s, exists := h.series[k]
+	if !exists {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.header(w, "histogram")
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.labelPairs(k, formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.labelPairs(k, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.n, h.labelPairs(k, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.n, h.labelPairs(k, ""), s.count)
+	}
+}
+
+////////
+//////// Gauges
+////////
+
+// Gauges whose values are read at collection time. Key: Value of the (single) label. Gauges without label use an empty key
+type GaugeFunc struct {
+	desc
+	f func() map[string]float64
+}
+
+// Single gauge read at collection time
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{n: Namespace + name, help: help}, f: func() map[string]float64 { return map[string]float64{"": f()} }}
+	register(g)
+	return g
+}
+
+// Gauges with a single label, read at collection time
+func NewGaugeVecFunc(name, help, label string, f func() map[string]float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{Namespace + name, help, []string{label}}, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	values := g.f()
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.n, g.labelPairs(k, ""), formatValue(values[k]))
+	}
+}
+
+////////
+//////// utils
+////////
+
+// Metric name, help and label names
+type desc struct {
+	n      string
+	help   string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.n
+}
+
+func (d *desc) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.n, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(d.help), d.n, kind)
+}
+
+// Label pairs of a series, plus the histogram "le" label (if any)
+func (d *desc) labelPairs(k, le string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(k, "\xff") {
+			if i < len(d.labels) {
+				pairs = append(pairs, d.labels[i]+"=\""+escape(value)+"\"")
+			}
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, "le=\""+le+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func key(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escape(s string) string {
+	return strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
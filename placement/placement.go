@@ -0,0 +1,170 @@
+package placement
+
+////
+//// Placement of a container on the Nuage VSD: Enterprise, Domain, Zone and Subnet (or L2Domain), plus Policy Groups and a static IP address.
+//// Given to the agent either as a "placement" JSON object or as OCI annotations with the "nuage.io/" prefix (e.g. from the bundle "config.json").
+//// For backward compatibility, the Domain, Zone, Subnet and L2Domain names may still be encoded in the corresponding ID lists of the container metadata.
+////
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Prefix of the container annotations understood by the agent and the OCI hook
+const AnnotationPrefix = "nuage.io/"
+
+// Container annotations
+const (
+	AnnotationName         = AnnotationPrefix + "name" // Container name on the agent and the VSD. Not part of the placement -- used by the OCI hook only
+	AnnotationEnterprise   = AnnotationPrefix + "enterprise"
+	AnnotationDomain       = AnnotationPrefix + "domain"
+	AnnotationZone         = AnnotationPrefix + "zone"
+	AnnotationSubnet       = AnnotationPrefix + "subnet"
+	AnnotationL2Domain     = AnnotationPrefix + "l2domain"      // Alternative to Domain, Zone and Subnet
+	AnnotationPolicyGroups = AnnotationPrefix + "policy-groups" // Comma separated Policy Group names
+	AnnotationIP           = AnnotationPrefix + "ip"            // Static IPv4 address
+)
+
+// Placement of a container. Either Domain, Zone and Subnet, or L2Domain
+type Placement struct {
+	Enterprise   string   `json:"enterprise"`
+	Domain       string   `json:"domain,omitempty"`
+	Zone         string   `json:"zone,omitempty"`
+	Subnet       string   `json:"subnet,omitempty"`
+	L2Domain     string   `json:"l2domain,omitempty"`
+	PolicyGroups []string `json:"policyGroups,omitempty"` // Policy Groups of the Domain (or L2Domain) the container VPort is assigned to
+	IP           string   `json:"ip,omitempty"`           // Static IPv4 address. Takes precedence over any IP Reservation of the container
+}
+
+// Container create request: Container metadata, plus its placement. The placement is taken from -- in order of precedence:
+// - "placement"
+// - the "nuage.io/*" entries of "annotations"
+// - the names encoded in the container "DomainIDs", "ZoneIDs", "SubnetIDs" and "L2DomainIDs" (deprecated)
+type Request struct {
+	vspk.Container
+	Placement   *Placement        `json:"placement,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Placement of the requested container. The Enterprise defaults to the container "EnterpriseName"
+func (req *Request) Resolve() (*Placement, error) {
+	p := req.Placement
+	if p == nil {
+		var err error
+		if p, err = FromAnnotations(req.Annotations); err != nil {
+			return nil, err
+		}
+	}
+	if p == nil {
+		var err error
+		if p, err = FromContainer(&req.Container); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Enterprise == "" {
+		p.Enterprise = req.EnterpriseName
+	}
+	return p, p.check()
+}
+
+// Placement in the "nuage.io/*" annotations of a container. Nil if there are none
+func FromAnnotations(annotations map[string]string) (*Placement, error) {
+	p := &Placement{}
+	found := false
+
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, AnnotationPrefix) {
+			continue
+		}
+
+		switch key {
+		case AnnotationName:
+			continue
+		case AnnotationEnterprise:
+			p.Enterprise = value
+		case AnnotationDomain:
+			p.Domain = value
+		case AnnotationZone:
+			p.Zone = value
+		case AnnotationSubnet:
+			p.Subnet = value
+		case AnnotationL2Domain:
+			p.L2Domain = value
+		case AnnotationPolicyGroups:
+			p.PolicyGroups = nil
+			for _, pg := range strings.Split(value, ",") {
+				if pg = strings.TrimSpace(pg); pg != "" {
+					p.PolicyGroups = append(p.PolicyGroups, pg)
+				}
+			}
+		case AnnotationIP:
+			p.IP = value
+		default:
+			return nil, fmt.Errorf("Unknown container annotation: %s", key)
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// Placement encoded in the ID lists of the container metadata, as expected by earlier agent versions
+func FromContainer(container *vspk.Container) (*Placement, error) {
+	p := &Placement{Enterprise: container.EnterpriseName}
+
+	var err error
+	if p.Domain, err = encodedName("DomainIDs", container.DomainIDs); err != nil {
+		return nil, err
+	}
+	if p.Zone, err = encodedName("ZoneIDs", container.ZoneIDs); err != nil {
+		return nil, err
+	}
+	if p.Subnet, err = encodedName("SubnetIDs", container.SubnetIDs); err != nil {
+		return nil, err
+	}
+	if p.L2Domain, err = encodedName("L2DomainIDs", container.L2DomainIDs); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+////////
+//////// utils
+////////
+
+// Check the static IP address and Policy Group names. The names themselves are validated against the VSD by the agent
+func (p *Placement) check() error {
+	if p.IP != "" {
+		if ip := net.ParseIP(p.IP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("Invalid static IP address: %s. Only IPv4 addresses are supported", p.IP)
+		}
+	}
+	for _, pg := range p.PolicyGroups {
+		if pg == "" {
+			return fmt.Errorf("Empty Policy Group name")
+		}
+	}
+	return nil
+}
+
+// Name encoded in a container ID list. Empty if the list is empty
+func encodedName(field string, ids []interface{}) (string, error) {
+	switch len(ids) {
+	case 0:
+		return "", nil
+	case 1:
+		if name, ok := ids[0].(string); ok {
+			return name, nil
+		}
+		return "", fmt.Errorf("Container %s: %v is not a name", field, ids[0])
+	}
+	return "", fmt.Errorf("Container %s: %d names instead of one", field, len(ids))
+}
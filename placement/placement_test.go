@@ -0,0 +1,159 @@
+package placement
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     Request
+		want    *Placement
+		wantErr bool
+	}{
+		{
+			name: "placement over annotations and container",
+			req: Request{
+				Container:   vspk.Container{EnterpriseName: "ent", DomainIDs: []interface{}{"legacy"}},
+				Placement:   &Placement{Enterprise: "explicit", Domain: "dom", Zone: "zone", Subnet: "sub"},
+				Annotations: map[string]string{AnnotationDomain: "annotated"},
+			},
+			want: &Placement{Enterprise: "explicit", Domain: "dom", Zone: "zone", Subnet: "sub"},
+		},
+		{
+			name: "annotations over container",
+			req: Request{
+				Container:   vspk.Container{EnterpriseName: "ent", DomainIDs: []interface{}{"legacy"}},
+				Annotations: map[string]string{AnnotationDomain: "dom", AnnotationZone: "zone", AnnotationSubnet: "sub"},
+			},
+			want: &Placement{Enterprise: "ent", Domain: "dom", Zone: "zone", Subnet: "sub"},
+		},
+		{
+			name: "container name annotation only",
+			req: Request{
+				Container:   vspk.Container{EnterpriseName: "ent", L2DomainIDs: []interface{}{"l2"}},
+				Annotations: map[string]string{AnnotationName: "c1", "other.io/key": "value"},
+			},
+			want: &Placement{Enterprise: "ent", L2Domain: "l2"},
+		},
+		{
+			name: "container ID lists",
+			req: Request{
+				Container: vspk.Container{EnterpriseName: "ent", DomainIDs: []interface{}{"dom"}, ZoneIDs: []interface{}{"zone"}, SubnetIDs: []interface{}{"sub"}},
+			},
+			want: &Placement{Enterprise: "ent", Domain: "dom", Zone: "zone", Subnet: "sub"},
+		},
+		{
+			name: "enterprise defaults to the container enterprise",
+			req: Request{
+				Container: vspk.Container{EnterpriseName: "ent"},
+				Placement: &Placement{L2Domain: "l2"},
+			},
+			want: &Placement{Enterprise: "ent", L2Domain: "l2"},
+		},
+		{
+			name: "annotated enterprise over the container enterprise",
+			req: Request{
+				Container:   vspk.Container{EnterpriseName: "ent"},
+				Annotations: map[string]string{AnnotationEnterprise: "annotated", AnnotationL2Domain: "l2"},
+			},
+			want: &Placement{Enterprise: "annotated", L2Domain: "l2"},
+		},
+		{
+			name: "unknown annotation",
+			req: Request{
+				Container:   vspk.Container{EnterpriseName: "ent", DomainIDs: []interface{}{"dom"}},
+				Annotations: map[string]string{AnnotationPrefix + "bogus": "value"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid static IP",
+			req: Request{
+				Placement: &Placement{Enterprise: "ent", L2Domain: "l2", IP: "2001:db8::1"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "several names in a container ID list",
+			req: Request{
+				Container: vspk.Container{EnterpriseName: "ent", DomainIDs: []interface{}{"dom1", "dom2"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ID instead of a name in a container ID list",
+			req: Request{
+				Container: vspk.Container{EnterpriseName: "ent", SubnetIDs: []interface{}{42}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := test.req.Resolve()
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Resolve() = %+v, want error", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(): %s", err)
+			}
+			if !reflect.DeepEqual(p, test.want) {
+				t.Errorf("Resolve() = %+v, want %+v", p, test.want)
+			}
+		})
+	}
+}
+
+func TestFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *Placement
+		wantErr     bool
+	}{
+		{name: "no annotations", annotations: nil, want: nil},
+		{name: "foreign annotations only", annotations: map[string]string{"other.io/zone": "zone"}, want: nil},
+		{name: "container name only", annotations: map[string]string{AnnotationName: "c1"}, want: nil},
+		{
+			name: "full placement",
+			annotations: map[string]string{
+				AnnotationName:         "c1",
+				AnnotationEnterprise:   "ent",
+				AnnotationDomain:       "dom",
+				AnnotationZone:         "zone",
+				AnnotationSubnet:       "sub",
+				AnnotationPolicyGroups: " web, ,db ",
+				AnnotationIP:           "10.0.0.5",
+			},
+			want: &Placement{Enterprise: "ent", Domain: "dom", Zone: "zone", Subnet: "sub", PolicyGroups: []string{"web", "db"}, IP: "10.0.0.5"},
+		},
+		{name: "empty policy groups", annotations: map[string]string{AnnotationPolicyGroups: " , "}, want: &Placement{}},
+		{name: "unknown annotation", annotations: map[string]string{AnnotationPrefix + "vlan": "10"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := FromAnnotations(test.annotations)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("FromAnnotations() = %+v, want error", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromAnnotations(): %s", err)
+			}
+			if !reflect.DeepEqual(p, test.want) {
+				t.Errorf("FromAnnotations() = %+v, want %+v", p, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+////
+//// Configuration and certificates reload, at SIGHUP or -- optionally -- when the files change
+////
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
+
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+)
+
+var configlog = logging.For(logging.Config)
+
+// How often the configuration and certificate files are checked for changes (with "-watch")
+const watchInterval = 5 * time.Second
+
+// Guards the "Config" pointer once the agent server runs. The running configuration is replaced as a whole at reload, never modified in place
+var configmutex sync.Mutex
+
+// Configuration fields that cannot be changed without restarting the agent, by YAML path prefix
+var restartFields = []string{
+	"agent-config.server-port",
+	"agent-config.caFile",
+	"listen-config.",
+	"audit-config.",
+	"lifecycle-config.state-file",
+	"debug-config.",
+}
+
+// Reload the configuration at SIGHUP and -- if "Watch" is set -- whenever the configuration or certificate files change
+func handleReloads() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if Watch {
+		tick = time.Tick(watchInterval)
+	}
+
+	mtimes := modTimes(runningConfig())
+
+	for {
+		select {
+		case <-sighup:
+			configlog.Info("Received SIGHUP. Reloading the configuration")
+		case <-tick:
+			if reflect.DeepEqual(modTimes(runningConfig()), mtimes) {
+				continue
+			}
+			configlog.Info("Configuration or certificate files changed. Reloading the configuration")
+		}
+
+		reload()
+		mtimes = modTimes(runningConfig())
+	}
+}
+
+// Load the configuration again, diff it against the running one and apply the changes that can be applied at runtime
+func reload() {
+	running := runningConfig()
+
+	newconf, err := config.Reload(flag.CommandLine)
+	if err != nil {
+		configlog.Errorf("Configuration reload rejected: %s", err)
+		return
+	}
+
+	var applied, rejected []string
+	vsdChanged, logChanged, traceChanged := false, false, false
+
+	for _, path := range config.Diff(running, newconf) {
+		switch {
+		case needsRestart(path):
+			rejected = append(rejected, path)
+		case strings.HasPrefix(path, "vsd-config."):
+			vsdChanged = true
+			applied = append(applied, path)
+		case strings.HasPrefix(path, "log-config."):
+			logChanged = true
+			applied = append(applied, path)
+		case strings.HasPrefix(path, "trace-config."):
+			traceChanged = true
+			applied = append(applied, path)
+		default:
+			applied = append(applied, path)
+		}
+	}
+
+	// Keep the running values of the fields that need a restart
+	newconf.AgentServer.ServerPort = running.AgentServer.ServerPort
+	newconf.AgentServer.CaFile = running.AgentServer.CaFile
+	newconf.Listen = running.Listen
+	newconf.Audit = running.Audit
+	newconf.Lifecycle.StateFile = running.Lifecycle.StateFile
+	newconf.Debug = running.Debug
+
+	// XXX - Log levels changed at runtime through the agent API are reset to the configured ones
+	if logChanged {
+		if err := logging.Configure(newconf.Log.Format, newconf.Log.File, newconf.Log.Levels.Map()); err != nil {
+			configlog.Errorf("Cannot apply the new logging configuration. Keeping the current one. Error: %s", err)
+			newconf.Log = running.Log
+			applied, rejected = movePrefix(applied, rejected, "log-config.")
+		}
+	}
+
+	if traceChanged {
+		if exporter, err := trace.NewExporter(newconf.Trace.Exporter, newconf.Trace.File); err != nil {
+			configlog.Errorf("Cannot apply the new tracing configuration. Keeping the current one. Error: %s", err)
+			newconf.Trace = running.Trace
+			applied, rejected = movePrefix(applied, rejected, "trace-config.")
+		} else {
+			trace.SetExporter(exporter)
+		}
+	}
+
+	// Reconnect to the VSD only if the VSD settings changed
+	if vsdChanged {
+		if err := vsdclient.Reconnect(newconf); err != nil {
+			configlog.Errorf("Cannot reconnect to the VSD with the new configuration. Keeping the current VSD session. Error: %s", err)
+			newconf.Vsd = running.Vsd
+			applied, rejected = movePrefix(applied, rejected, "vsd-config.")
+		}
+	}
+
+	// XXX - Always re-load the agent server certificate, as it may have been rotated in place (i.e. same file names)
+	if err := server.Reload(newconf); err != nil {
+		newconf.AgentServer.CertCaFile = running.AgentServer.CertCaFile
+		newconf.AgentServer.KeyFile = running.AgentServer.KeyFile
+		applied, rejected = movePrefix(applied, rejected, "agent-config.certcaFile")
+		applied, rejected = movePrefix(applied, rejected, "agent-config.keyFile")
+	}
+
+	for _, path := range applied {
+		configlog.Infof("Configuration change applied: %s", path)
+	}
+	for _, path := range rejected {
+		configlog.Warningf("Configuration change rejected: %s", path)
+	}
+
+	setConfig(newconf)
+	configlog.Infof("Configuration reload completed: %d change(s) applied, %d change(s) rejected", len(applied), len(rejected))
+}
+
+////////
+//////// utils
+////////
+
+// Snapshot of the running configuration
+func runningConfig() *config.Config {
+	configmutex.Lock()
+	defer configmutex.Unlock()
+	return Config
+}
+
+func setConfig(conf *config.Config) {
+	configmutex.Lock()
+	Config = conf
+	configmutex.Unlock()
+}
+
+func needsRestart(path string) bool {
+	for _, prefix := range restartFields {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the paths with a given prefix from the applied to the rejected changes
+func movePrefix(applied, rejected []string, prefix string) ([]string, []string) {
+	var kept []string
+	for _, path := range applied {
+		if strings.HasPrefix(path, prefix) {
+			rejected = append(rejected, path)
+		} else {
+			kept = append(kept, path)
+		}
+	}
+	return kept, rejected
+}
+
+// Modification times of the configuration file and of the certificates it refers to. Missing files have a zero time
+func modTimes(conf *config.Config) map[string]time.Time {
+	mtimes := make(map[string]time.Time)
+	for _, file := range []string{conf.ConfigFile, conf.Vsd.CertFile, conf.Vsd.KeyFile, conf.AgentServer.CertCaFile, conf.AgentServer.KeyFile} {
+		if fi, err := os.Stat(file); err == nil {
+			mtimes[file] = fi.ModTime()
+		} else {
+			mtimes[file] = time.Time{}
+		}
+	}
+	return mtimes
+}
@@ -0,0 +1,212 @@
+package server
+
+////
+//// Audit log of the changes made through the agent API: Who changed which object, how, and the resulting VSD operations
+////
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/audit"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+type vsdOpsKey struct{}
+
+// VSD operations resulting from a given agent API call
+type vsdOps struct {
+	ops   []string
+	mutex sync.Mutex
+}
+
+// Audit log. Nil: No auditing
+var auditlog *audit.Log
+
+// Make changes even if they cannot be recorded in the audit log
+var auditFailOpen bool
+
+// Digest of the current state of an object of a given resource, by name. Empty if no such object
+// XXX - Callers must hold "cachemutex" for the agent cache resources: Networks, Containers and Interfaces
+var snapshots = map[string]func(name string) string{
+	ResourceNetworks: func(name string) string {
+		if network, exists := agent.Networks[name]; exists {
+			return audit.Digest(network)
+		}
+		return ""
+	},
+	ResourceContainers: func(name string) string {
+		if container, exists := agent.Containers[name]; exists {
+			return audit.Digest(container)
+		}
+		return ""
+	},
+	ResourceInterfaces: func(name string) string {
+		if ifaces, exists := agent.Interfaces[name]; exists {
+			return audit.Digest(ifaces)
+		}
+		return ""
+	},
+	ResourceMirrors: func(name string) string {
+		mirrorsmutex.Lock()
+		defer mirrorsmutex.Unlock()
+		if mirror, exists := Mirrors[name]; exists {
+			return audit.Digest(mirror)
+		}
+		return ""
+	},
+	ResourceReservations: func(name string) string {
+		reservationsmutex.Lock()
+		defer reservationsmutex.Unlock()
+		if reservation, exists := Reservations[name]; exists {
+			return audit.Digest(reservation)
+		}
+		return ""
+	},
+	ResourceVIPs: func(name string) string {
+		vipsmutex.Lock()
+		defer vipsmutex.Unlock()
+		if vip, exists := VIPs[name]; exists {
+			return audit.Digest(vip)
+		}
+		return ""
+	},
+	ResourceLogging: func(name string) string {
+		return logging.Levels()[name]
+	},
+	ResourceRedirectionTargets: func(name string) string {
+		redirectionmutex.Lock()
+		defer redirectionmutex.Unlock()
+		if rt, exists := RedirectionTargets[name]; exists {
+			return audit.Digest(rt)
+		}
+		return ""
+	},
+}
+
+// Run the handler of an agent API change, then append an audit record of it -- if auditing is enabled
+func audited(resource string, handler http.HandlerFunc, w http.ResponseWriter, req *http.Request) {
+	if auditlog == nil {
+		handler(w, req)
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	if name == "" && resource == ResourceNetworks {
+		name = networkName(req)
+	}
+
+	ops := &vsdOps{}
+	setContext(req, context.WithValue(req.Context(), vsdOpsKey{}, ops))
+	before := snapshots[resource](name)
+
+	client := ""
+	if c := requestClient(req); c != nil {
+		client = c.identity
+	}
+
+	// Unless auditing fails open, the change is only made once it is recorded as about to be made
+	if !auditFailOpen {
+		intent := &audit.Record{Client: client, Method: req.Method, Route: req.URL.Path, Resource: resource, Object: name, Before: before}
+		err := auditlog.Append(intent)
+		if _, stale := err.(*audit.HeadError); stale {
+			log.Errorf("Recorded %s %s with a stale audit log head. Error: %s", req.Method, req.URL.Path, err)
+		} else if err != nil {
+			log.Errorf("Refused %s %s. Cannot append audit record. Error: %s", req.Method, req.URL.Path, err)
+			agent.Sendjson(w, bambou.NewBambouError(AuditCannotRecord+req.Method+" "+req.URL.Path, err.Error()), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	handler(rec, req)
+
+	record := &audit.Record{
+		Client:   client,
+		Method:   req.Method,
+		Route:    req.URL.Path,
+		Resource: resource,
+		Object:   name,
+		Status:   rec.status,
+		Before:   before,
+		After:    snapshots[resource](name),
+		VSD:      ops.ops,
+	}
+
+	// XXX - The change is made by now. With fail-closed auditing, its intent is recorded
+	if err := auditlog.Append(record); err != nil {
+		log.Errorf("Failed to append audit record for %s %s. Error: %s", req.Method, req.URL.Path, err)
+	}
+}
+
+// Make a change of the agent itself -- e.g. a drift repair -- then append an audit record of it, as made by a given client (e.g. "agent:drift-audit") on the equivalent agent API route.
+// The change is made even if it cannot be recorded
+// XXX - Callers must hold "cachemutex" for the agent cache resources: Networks, Containers and Interfaces
+func auditedChange(ctx context.Context, client, method, route, resource, name string, change func(ctx context.Context)) {
+	if auditlog == nil {
+		change(ctx)
+		return
+	}
+
+	ops := &vsdOps{}
+	before := snapshots[resource](name)
+	change(context.WithValue(ctx, vsdOpsKey{}, ops))
+
+	ops.mutex.Lock()
+	record := &audit.Record{
+		Client:   client,
+		Method:   method,
+		Route:    route,
+		Resource: resource,
+		Object:   name,
+		Status:   http.StatusOK,
+		Before:   before,
+		After:    snapshots[resource](name),
+		VSD:      ops.ops,
+	}
+	ops.mutex.Unlock()
+
+	if err := auditlog.Append(record); err != nil {
+		log.Errorf("Failed to append audit record for %s %s by: %s. Error: %s", method, route, client, err)
+	}
+}
+
+// Record a VSD operation resulting from the agent API call in a given context (if any)
+func auditVSD(ctx context.Context, format string, args ...interface{}) {
+	ops, ok := ctx.Value(vsdOpsKey{}).(*vsdOps)
+	if !ok {
+		return
+	}
+
+	ops.mutex.Lock()
+	ops.ops = append(ops.ops, fmt.Sprintf(format, args...))
+	ops.mutex.Unlock()
+}
+
+////////
+//////// Util
+////////
+
+// Name of the CNI network in a Network POST request. The request body is left intact for the handler
+func networkName(req *http.Request) string {
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return ""
+	}
+
+	var netconf struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(data, &netconf)
+	return netconf.Name
+}
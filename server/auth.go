@@ -0,0 +1,161 @@
+package server
+
+////
+//// Client authentication: Mutual TLS against the agent CA certificate, plus an (optional) allow-list of client identities
+////
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+type clientKey struct{}
+
+// Authenticated agent API client
+type apiClient struct {
+	identity string
+	conf     *config.ClientConfig // nil: No allowed clients configured, i.e. any client is an admin
+}
+
+var (
+	// Clients allowed to use the agent API. Empty: Any client with a valid certificate
+	clients      []config.ClientConfig
+	clientsmutex sync.RWMutex
+)
+
+// Set the clients allowed to use the agent API. Swapped at configuration reload
+func setClients(cl []config.ClientConfig) {
+	clientsmutex.Lock()
+	clients = cl
+	clientsmutex.Unlock()
+}
+
+// Pool with the CA certificate(s) client certificates must chain to
+func clientCAs(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, bambou.NewBambouError("Cannot load agent server CA certificate: "+caFile, "No certificates found")
+	}
+
+	return pool, nil
+}
+
+// Check the (verified) client certificate against the allow-list, then log who made each change.
+// The client is passed on to the handlers in the request context (see "requestClient")
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+			log.Warningf("Rejected %s %s from: %s. No client certificate", req.Method, req.URL.Path, req.RemoteAddr)
+			agent.Sendjson(w, bambou.NewBambouError(ClientNotAllowed+req.RemoteAddr, "Client certificate required"), http.StatusUnauthorized)
+			return
+		}
+
+		cert := req.TLS.PeerCertificates[0]
+		client, allowed := allowedClient(certIdentities(cert), false, true)
+		if !allowed {
+			log.Warningf("Rejected %s %s from client: %s at: %s. Not in the allowed clients", req.Method, req.URL.Path, cert.Subject, req.RemoteAddr)
+			agent.Sendjson(w, bambou.NewBambouError(ClientNotAllowed+cert.Subject.String(), "Client not in the allowed clients"), http.StatusForbidden)
+			return
+		}
+
+		serveClient(w, req, client, next)
+	})
+}
+
+// Serve the request of an authenticated client, logging the changes it makes
+func serveClient(w http.ResponseWriter, req *http.Request, client *apiClient, next http.Handler) {
+	req = withRequestID(w, req.WithContext(context.WithValue(req.Context(), clientKey{}, client)))
+
+	if req.Method == "GET" {
+		next.ServeHTTP(w, req)
+		return
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, req)
+	log.With("client", client.identity, "method", req.Method, "path", req.URL.Path, "status", rec.status, "request_id", trace.RequestID(req.Context())).
+		Infof("Client: %s at: %s -- %s %s -- Status: %d", client.identity, req.RemoteAddr, req.Method, req.URL.Path, rec.status)
+}
+
+// Client making a given request. Nil if unknown
+func requestClient(req *http.Request) *apiClient {
+	client, _ := req.Context().Value(clientKey{}).(*apiClient)
+	return client
+}
+
+////////
+//////// Util
+////////
+
+// Find the allowed client matching any of the given identities. Unix domain socket peer identities ("peer") only match allowed "uid:" / "gid:" identities, certificate identities never do.
+// If the allow-list is empty, the client is allowed -- as an admin, under its first identity -- if "defaultAllowed" is set
+func allowedClient(identities []string, peer, defaultAllowed bool) (*apiClient, bool) {
+	clientsmutex.RLock()
+	defer clientsmutex.RUnlock()
+
+	if len(clients) == 0 {
+		if !defaultAllowed {
+			return nil, false
+		}
+		return &apiClient{identity: identities[0]}, true
+	}
+
+	for i := range clients {
+		if isPeerIdentity(clients[i].Identity) != peer {
+			continue
+		}
+		for _, identity := range identities {
+			if clients[i].Identity == identity {
+				return &apiClient{identity: identity, conf: &clients[i]}, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// All the names a client certificate can be identified by: Common Name, Subject DN and SANs
+func certIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.Subject.String())
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
+
+// Response writer recording the response status
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Streamed responses, e.g. server-sent events
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+////
+//// Client authorization: Roles and (optional) Enterprise / Zone scoping of agent API operations
+////
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+// Agent API resources, as used in authorization actions, e.g. "containers:write"
+const (
+	ResourceNetworks           = "networks"
+	ResourceContainers         = "containers"
+	ResourceInterfaces         = "interfaces"
+	ResourceMirrors            = "mirrors"
+	ResourceReservations       = "reservations"
+	ResourceVIPs               = "vips"
+	ResourceRedirectionTargets = "redirectiontargets"
+	ResourceMetrics            = "metrics"
+	ResourceHealth             = "health"
+	ResourceLogging            = "loglevels"
+	ResourceDebug              = "debug"
+	ResourceEvents             = "events"
+	ResourceDrift              = "drift"
+)
+
+// Resources each role may change. Any role may read any resource -- except the admin only ones. Admins may change any resource
+var roleWrites = map[string][]string{
+	config.RoleReadOnly:        nil,
+	config.RoleContainerWriter: {ResourceContainers, ResourceInterfaces, ResourceReservations},
+	config.RoleNetworkAdmin:    {ResourceNetworks, ResourceMirrors, ResourceVIPs, ResourceRedirectionTargets},
+}
+
+// Resources only admins may read
+var adminResources = map[string]bool{
+	ResourceDebug: true,
+}
+
+// Resources keyed by container name, i.e. subject to the Enterprise / Zone scoping of the client
+var containerResources = map[string]bool{
+	ResourceContainers:   true,
+	ResourceInterfaces:   true,
+	ResourceReservations: true,
+	ResourceMirrors:      true,
+}
+
+// Enterprise and Zone of a container
+type containerScope struct {
+	enterprise string
+	zone       string
+}
+
+var (
+	// Enterprise and Zone of the cached containers -- which are no longer part of the cached container metadata
+	// Key: Container Name
+	containerScopes = make(map[string]containerScope)
+
+	scopesmutex sync.Mutex
+)
+
+func init() {
+	containerCleanups = append(containerCleanups, func(ctx context.Context, name string) {
+		scopesmutex.Lock()
+		delete(containerScopes, name)
+		scopesmutex.Unlock()
+	})
+}
+
+// Enforce the role -- and, for changes to existing containers, the Enterprise / Zone scope -- of the client in front of a given handler
+func authorize(resource string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer observeRequest(req, rec, time.Now())
+		w = rec
+
+		defer traced(req, rec, resource)()
+		defer trackRequest(req)()
+
+		write := req.Method != "GET"
+		action := resource + ":read"
+		if write {
+			action = resource + ":write"
+		}
+
+		client := requestClient(req)
+		if client == nil || !client.allowed(resource, write) {
+			deny(w, req, action, "Action not allowed for the client role")
+			return
+		}
+
+		// Containers without a recorded scope are resolved on the VSD. Unknown ones may only be created -- the handler checks the scope of their placement
+		if write && containerResources[resource] && client.scoped() {
+			scope, exists, err := lookupScope(req.Context(), mux.Vars(req)["name"])
+			switch {
+			case err != nil:
+				deny(w, req, action, "Cannot resolve the container Enterprise and Zone: "+err.Error())
+				return
+			case !exists && !(resource == ResourceContainers && req.Method == "PUT"):
+				deny(w, req, action, "Unknown container Enterprise and Zone")
+				return
+			case exists && !client.inScope(scope.enterprise, scope.zone):
+				deny(w, req, action, "Container Enterprise: "+scope.enterprise+" and Zone: "+scope.zone+" not allowed for the client")
+				return
+			}
+		}
+
+		if write {
+			audited(resource, handler, w, req)
+		} else {
+			handler(w, req)
+		}
+	}
+}
+
+// Check the client making a request may place a container in a given Enterprise and Zone. If not, respond with the denied action
+func authorizeScope(w http.ResponseWriter, req *http.Request, enterprise, zone string) bool {
+	if client := requestClient(req); client == nil || !client.inScope(enterprise, zone) {
+		deny(w, req, ResourceContainers+":write", "Container Enterprise: "+enterprise+" and Zone: "+zone+" not allowed for the client")
+		return false
+	}
+	return true
+}
+
+// Record the Enterprise and Zone of a cached container
+func recordScope(name, enterprise, zone string) {
+	scopesmutex.Lock()
+	containerScopes[name] = containerScope{enterprise: enterprise, zone: zone}
+	scopesmutex.Unlock()
+}
+
+////////
+//////// Util
+////////
+
+// Enterprise and Zone of a container: As recorded when it was cached or, if not cached, as found on the VSD. Not found if neither
+func lookupScope(ctx context.Context, name string) (containerScope, bool, error) {
+	scopesmutex.Lock()
+	scope, exists := containerScopes[name]
+	scopesmutex.Unlock()
+
+	if exists {
+		return scope, true, nil
+	}
+
+	container := &vsdclient.Container{Name: name}
+	if err := container.FetchByName(ctx); err != nil {
+		return containerScope{}, false, err
+	}
+	if container.ID == "" {
+		return containerScope{}, false, nil
+	}
+
+	cifaces, err := container.FetchInterfaces(ctx)
+	if err != nil {
+		return containerScope{}, false, err
+	}
+
+	// XXX - Containers without interfaces have no Zone
+	scope = containerScope{enterprise: container.EnterpriseName}
+	if len(cifaces) > 0 {
+		scope.zone = cifaces[0].ZoneName
+		if tenant := vsdclient.GetTenantByDomainID(cifaces[0].DomainID); tenant != nil {
+			scope.enterprise = tenant.Enterprise.Name
+		}
+	}
+	return scope, true, nil
+}
+
+func (client *apiClient) role() string {
+	if client.conf == nil || client.conf.Role == "" {
+		return config.RoleAdmin
+	}
+	return client.conf.Role
+}
+
+// Check the client role allows reading -- or changing -- a given resource
+func (client *apiClient) allowed(resource string, write bool) bool {
+	role := client.role()
+	if role == config.RoleAdmin {
+		return true
+	}
+
+	writes, valid := roleWrites[role]
+	if !valid || adminResources[resource] {
+		return false
+	}
+
+	return !write || contains(writes, resource)
+}
+
+// Whether the client may only change containers in some Enterprises or Zones
+func (client *apiClient) scoped() bool {
+	return client.conf != nil && (len(client.conf.Enterprises) > 0 || len(client.conf.Zones) > 0)
+}
+
+// Check the client may change containers in a given Enterprise and Zone
+func (client *apiClient) inScope(enterprise, zone string) bool {
+	if client.conf == nil {
+		return true
+	}
+
+	return (len(client.conf.Enterprises) == 0 || contains(client.conf.Enterprises, enterprise)) &&
+		(len(client.conf.Zones) == 0 || contains(client.conf.Zones, zone))
+}
+
+func deny(w http.ResponseWriter, req *http.Request, action, reason string) {
+	identity := "<unknown>"
+	if client := requestClient(req); client != nil {
+		identity = client.identity
+	}
+
+	log.Warningf("Denied action: %s to client: %s -- %s %s. %s", action, identity, req.Method, req.URL.Path, reason)
+	agent.Sendjson(w, bambou.NewBambouError(ActionDenied+action, reason), http.StatusForbidden)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
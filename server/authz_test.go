@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/gorilla/mux"
+)
+
+func TestAuthorize(t *testing.T) {
+	admin := &config.ClientConfig{Identity: "admin", Role: config.RoleAdmin}
+	readOnly := &config.ClientConfig{Identity: "ro", Role: config.RoleReadOnly}
+	writer := &config.ClientConfig{Identity: "writer", Role: config.RoleContainerWriter}
+	netAdmin := &config.ClientConfig{Identity: "netadmin", Role: config.RoleNetworkAdmin}
+	bogus := &config.ClientConfig{Identity: "bogus", Role: "superuser"}
+	scoped := &config.ClientConfig{Identity: "scoped", Role: config.RoleContainerWriter, Enterprises: []string{"ent1"}, Zones: []string{"zone1"}}
+	entScoped := &config.ClientConfig{Identity: "entscoped", Role: config.RoleContainerWriter, Enterprises: []string{"ent1"}}
+	scopedAdmin := &config.ClientConfig{Identity: "scopedadmin", Role: config.RoleAdmin, Zones: []string{"zone1"}}
+
+	// Recorded container scopes: No VSD lookups
+	recordScope("c-ent1-zone1", "ent1", "zone1")
+	recordScope("c-ent2-zone1", "ent2", "zone1")
+	recordScope("c-ent1-zone2", "ent1", "zone2")
+	defer func() {
+		scopesmutex.Lock()
+		containerScopes = make(map[string]containerScope)
+		scopesmutex.Unlock()
+	}()
+
+	tests := []struct {
+		name     string
+		noClient bool
+		client   *config.ClientConfig // nil: No allowed clients configured
+		resource string
+		method   string
+		object   string
+		body     string           // Request body of "handler"
+		handler  http.HandlerFunc // nil: Any authorized request succeeds
+		want     int
+	}{
+		// Roles
+		{name: "no client", noClient: true, resource: ResourceContainers, method: "GET", want: http.StatusForbidden},
+		{name: "default admin write", resource: ResourceNetworks, method: "POST", want: http.StatusOK},
+		{name: "default admin debug", resource: ResourceDebug, method: "GET", want: http.StatusOK},
+		{name: "admin write", client: admin, resource: ResourceRedirectionTargets, method: "DELETE", want: http.StatusOK},
+		{name: "admin debug", client: admin, resource: ResourceDebug, method: "GET", want: http.StatusOK},
+		{name: "read-only read", client: readOnly, resource: ResourceContainers, method: "GET", want: http.StatusOK},
+		{name: "read-only write", client: readOnly, resource: ResourceContainers, method: "PUT", want: http.StatusForbidden},
+		{name: "read-only debug", client: readOnly, resource: ResourceDebug, method: "GET", want: http.StatusForbidden},
+		{name: "container-writer container", client: writer, resource: ResourceContainers, method: "PUT", want: http.StatusOK},
+		{name: "container-writer interfaces", client: writer, resource: ResourceInterfaces, method: "DELETE", want: http.StatusOK},
+		{name: "container-writer reservation", client: writer, resource: ResourceReservations, method: "PUT", want: http.StatusOK},
+		{name: "container-writer mirror", client: writer, resource: ResourceMirrors, method: "PUT", want: http.StatusForbidden},
+		{name: "container-writer network", client: writer, resource: ResourceNetworks, method: "POST", want: http.StatusForbidden},
+		{name: "container-writer read", client: writer, resource: ResourceVIPs, method: "GET", want: http.StatusOK},
+		{name: "network-admin network", client: netAdmin, resource: ResourceNetworks, method: "DELETE", want: http.StatusOK},
+		{name: "network-admin VIP", client: netAdmin, resource: ResourceVIPs, method: "PUT", want: http.StatusOK},
+		{name: "network-admin container", client: netAdmin, resource: ResourceContainers, method: "PUT", want: http.StatusForbidden},
+		{name: "network-admin debug", client: netAdmin, resource: ResourceDebug, method: "GET", want: http.StatusForbidden},
+		{name: "unknown role read", client: bogus, resource: ResourceContainers, method: "GET", want: http.StatusForbidden},
+
+		// Scopes
+		{name: "scoped in scope", client: scoped, resource: ResourceContainers, object: "c-ent1-zone1", method: "PUT", want: http.StatusOK},
+		{name: "scoped other enterprise", client: scoped, resource: ResourceContainers, object: "c-ent2-zone1", method: "PUT", want: http.StatusForbidden},
+		{name: "scoped other zone", client: scoped, resource: ResourceInterfaces, object: "c-ent1-zone2", method: "DELETE", want: http.StatusForbidden},
+		{name: "scoped read out of scope", client: scoped, resource: ResourceContainers, object: "c-ent2-zone1", method: "GET", want: http.StatusOK},
+		{name: "scoped reservation out of scope", client: scoped, resource: ResourceReservations, object: "c-ent2-zone1", method: "PUT", want: http.StatusForbidden},
+		{name: "scoped role first", client: scoped, resource: ResourceNetworks, object: "net1", method: "POST", want: http.StatusForbidden},
+		{name: "enterprise scoped any zone", client: entScoped, resource: ResourceContainers, object: "c-ent1-zone2", method: "DELETE", want: http.StatusOK},
+		{name: "enterprise scoped other enterprise", client: entScoped, resource: ResourceContainers, object: "c-ent2-zone1", method: "DELETE", want: http.StatusForbidden},
+		{name: "scoped admin mirror out of scope", client: scopedAdmin, resource: ResourceMirrors, object: "c-ent1-zone2", method: "PUT", want: http.StatusForbidden},
+		{name: "scoped admin VIP", client: scopedAdmin, resource: ResourceVIPs, object: "c-ent1-zone2", method: "PUT", want: http.StatusOK},
+		{name: "unscoped any container", client: writer, resource: ResourceContainers, object: "c-unknown", method: "DELETE", want: http.StatusOK},
+		{
+			name: "scoped container renamed out of scope", client: scoped, resource: ResourceContainers, object: "c-ent1-zone1", method: "PUT",
+			body: `{"name": "c-ent2-zone1", "placement": {"enterprise": "ent1", "domain": "dom1", "zone": "zone1", "subnet": "sub1"}}`, handler: putContainer,
+			want: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			object := test.object
+			if object == "" {
+				object = "object"
+			}
+
+			handler := test.handler
+			if handler == nil {
+				handler = func(w http.ResponseWriter, req *http.Request) {
+					// The route variables must survive the request context changes
+					if mux.Vars(req)["name"] != object {
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					w.WriteHeader(http.StatusOK)
+				}
+			}
+
+			router := mux.NewRouter()
+			router.HandleFunc("/"+test.resource+"/{name}", authorize(test.resource, handler))
+
+			req := httptest.NewRequest(test.method, "/"+test.resource+"/"+object, strings.NewReader(test.body))
+			if !test.noClient {
+				identity := "client"
+				if test.client != nil {
+					identity = test.client.Identity
+				}
+				req = req.WithContext(context.WithValue(req.Context(), clientKey{}, &apiClient{identity: identity, conf: test.client}))
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Errorf("%s %s as %s: status %d, want %d", test.method, req.URL.Path, test.name, rec.Code, test.want)
+			}
+		})
+	}
+}
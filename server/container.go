@@ -0,0 +1,201 @@
+package server
+
+////
+//// Container caching, with validation of the container placement against the local configuration
+////
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-cni/errors"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/placement"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Local handler for ContainerPUT.
+
+func putContainer(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	creq := placement.Request{}
+	_, decoded := step(req.Context(), "container.decode")
+	err := json.NewDecoder(req.Body).Decode(&creq)
+	decoded(err)
+	if err != nil {
+		log.Errorf("Container create request - JSON decoding error: %s", err)
+		validationFailures.Inc("json")
+		agent.Sendjson(w, bambou.NewBambouError(errors.ContainerCannotCreate+vars["name"], "JSON decoding error"), http.StatusBadRequest)
+		return
+	}
+	newc := creq.Container
+
+	// The container is authorized -- and cached -- by the name in the path
+	if newc.Name == "" {
+		newc.Name = vars["name"]
+	}
+	if newc.Name != vars["name"] {
+		log.Errorf("Container create request - Container name: %s does not match the request path", newc.Name)
+		validationFailures.Inc("name")
+		agent.Sendjson(w, bambou.NewBambouError(errors.ContainerCannotCreate+vars["name"], "Container name: "+newc.Name+" does not match the request path"), http.StatusBadRequest)
+		return
+	}
+
+	// Placement given explicitly, as "nuage.io/*" annotations, or encoded in the container ID lists
+	p, err := creq.Resolve()
+	if err != nil {
+		log.Errorf("Container create request - Invalid placement: %s", err)
+		validationFailures.Inc("placement")
+		agent.Sendjson(w, bambou.NewBambouError(errors.ContainerCannotCreate+vars["name"], err.Error()), http.StatusBadRequest)
+		return
+	}
+	newc.EnterpriseName = p.Enterprise
+
+	clog := log.With("container", vars["name"], "enterprise", p.Enterprise, "zone", p.Zone, "subnet", p.Subnet)
+
+	// The client must be allowed to place containers in the Enterprise and Zone of the container placement
+	if !authorizeScope(w, req, p.Enterprise, p.Zone) {
+		validationFailures.Inc("scope")
+		return
+	}
+
+	// Container placement is either an L2Domain or a Domain, Zone and Subnet
+	ctx, validated := step(req.Context(), "container.validate")
+	if p.L2Domain != "" {
+		err = validateL2Container(ctx, &newc, p)
+	} else {
+		err = validateL3Container(ctx, &newc, p)
+	}
+	validated(err)
+
+	if err != nil {
+		clog.Errorf("Container create request error: %s", err)
+		validationFailed(err)
+		agent.Sendjson(w, bambou.NewBambouError(errors.ContainerCannotCreate+vars["name"], err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	// The placement is kept by the agent, not in the container metadata
+	newc.DomainIDs = nil
+	newc.ZoneIDs = nil
+	newc.SubnetIDs = nil
+	newc.L2DomainIDs = nil
+
+	//
+	////
+	////  ...Any additional processing at Container caching
+	////
+
+	agent.Containers[newc.Name] = newc
+	recordScope(newc.Name, p.Enterprise, p.Zone)
+	recordPlacement(newc.Name, p)
+
+	////
+	//// Response ....
+	////
+
+	clog.Infof("Successfully cached Nuage Container: %s", newc.Name)
+	agent.Sendjson(w, nil, http.StatusCreated)
+}
+
+////////
+//////// Util
+////////
+
+// Validate the Enterprise, Domain, Zone and Subnet names in the placement of a container to be placed in an L3 Domain, plus its Policy Groups and static IP address
+func validateL3Container(ctx context.Context, newc *vspk.Container, p *placement.Placement) error {
+	if p.Domain == "" {
+		return invalid("no-domain", "No container Domain")
+	}
+
+	// Route the request to the tenant context matching the Enterprise and Domain names in container placement
+	tenant := vsdclient.GetTenant(p.Enterprise, p.Domain)
+	if tenant == nil {
+		return invalid("unknown-tenant", "Container Enterprise Name: %s and Domain Name: %s do not match local configuration", p.Enterprise, p.Domain)
+	}
+	log.Infof("Validated Container placement - Enterprise: %s, Domain: %s", tenant.Enterprise.Name, tenant.Domain.Name)
+
+	if p.Zone == "" {
+		return invalid("no-zone", "No container Zone")
+	}
+
+	if tenant.GetZone(ctx, p.Zone) == nil {
+		return invalid("unknown-zone", "Container Zone Name: %s does not match local configuration", p.Zone)
+	}
+	log.Infof("Validated Container placement - Zone: %s", p.Zone)
+
+	if p.Subnet == "" {
+		return invalid("no-subnet", "No container Subnet")
+	}
+
+	subnet := tenant.GetSubnet(ctx, p.Subnet)
+	if subnet == nil {
+		return invalid("unknown-subnet", "Container Subnet Name: %s does not match local configuration", p.Subnet)
+	}
+	log.Infof("Validated Container placement - Subnet: %s", p.Subnet)
+
+	if err := validatePolicyGroups(ctx, tenant, p); err != nil {
+		return err
+	}
+
+	if p.IP == "" {
+		// Re-use the sticky IP address of this container, if any
+		applyReservation(newc, p)
+		return nil
+	}
+
+	prefix := net.IPNet{IP: net.ParseIP(subnet.Address), Mask: net.IPMask(net.ParseIP(subnet.Netmask).To4())}
+	if !prefix.Contains(net.ParseIP(p.IP)) {
+		return invalid("invalid-ip", "Container static IP address: %s is not part of Subnet: %s", p.IP, p.Subnet)
+	}
+	applyStaticIP(newc, p)
+
+	return nil
+}
+
+// Validate the Enterprise and L2Domain names in the placement of a container to be placed in an L2Domain, plus its Policy Groups and addressing.
+// XXX - No Zones or Subnets in an L2Domain
+func validateL2Container(ctx context.Context, newc *vspk.Container, p *placement.Placement) error {
+	if p.Domain != "" {
+		return invalid("ambiguous-domain", "Container placement has both Domain: %s and L2Domain: %s", p.Domain, p.L2Domain)
+	}
+
+	tenant := vsdclient.GetL2Tenant(p.Enterprise, p.L2Domain)
+	if tenant == nil {
+		return invalid("unknown-l2domain", "Container Enterprise Name: %s and L2Domain Name: %s do not match local configuration", p.Enterprise, p.L2Domain)
+	}
+	log.Infof("Validated Container placement - Enterprise: %s, L2Domain: %s", tenant.Enterprise.Name, tenant.L2Domain.Name)
+
+	if err := validatePolicyGroups(ctx, tenant, p); err != nil {
+		return err
+	}
+
+	if p.IP != "" {
+		(*vsdclient.Container)(newc).SetIP(p.IP)
+	}
+
+	if err := tenant.L2Addressing((*vsdclient.Container)(newc)); err != nil {
+		return &validationError{reason: "l2-addressing", err: err}
+	}
+
+	return nil
+}
+
+// Check the Policy Groups of the container placement exist in the tenant Domain (or L2Domain)
+func validatePolicyGroups(ctx context.Context, tenant *vsdclient.Tenant, p *placement.Placement) error {
+	if len(p.PolicyGroups) == 0 {
+		return nil
+	}
+
+	if _, err := tenant.GetPolicyGroups(ctx, p.PolicyGroups); err != nil {
+		return &validationError{reason: "unknown-policy-group", err: err}
+	}
+	log.Infof("Validated Container placement - Policy Groups: %v", p.PolicyGroups)
+	return nil
+}
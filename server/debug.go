@@ -0,0 +1,280 @@
+package server
+
+////
+//// Debugging aids: Dump of the agent internal state on the agent API (admin only), plus "net/http/pprof" on an opt-in admin listener
+////
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	nuagecnitypes "github.com/OpenPlatformSDN/nuage-cni/types"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/placement"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+const (
+	DebugStatePath = "/debug/state" // Agent server relative path for the dump of the agent internal state
+)
+
+// Agent internal state
+type debugState struct {
+	Time      time.Time              `json:"time"`
+	Started   time.Time              `json:"started"`
+	VSD       *vsdclient.ClientState `json:"vsd"`
+	IPAM      []subnetUsage          `json:"ipam"`
+	Caches    debugCaches            `json:"caches"`
+	Pending   pendingOperations      `json:"pending"`
+	Readiness *Readiness             `json:"readiness"`
+	Listeners map[string]bool        `json:"listeners"`
+	Scopes    map[string]savedScope  `json:"scopes"`
+}
+
+// Contents of the agent caches
+type debugCaches struct {
+	Networks           map[string]nuagecnitypes.NetConf  `json:"networks"`
+	Containers         map[string]vspk.Container         `json:"containers"`
+	Interfaces         map[string][]nuagecnitypes.Result `json:"interfaces"`
+	Mirrors            map[string]*vsdclient.Mirror      `json:"mirrors"`
+	Reservations       map[string]*vsdclient.Reservation `json:"reservations"`
+	VIPs               map[string]*vsdclient.VIP         `json:"vips"`
+	RedirectionTargets map[string]*redirectionTarget     `json:"redirectionTargets"`
+	Placements         map[string]*placement.Placement   `json:"placements"`
+}
+
+// IP address usage of a Subnet (or L2Domain), as seen by this agent
+type subnetUsage struct {
+	Subnet  string  `json:"subnet"`
+	Zone    string  `json:"zone,omitempty"`
+	Prefix  string  `json:"prefix"`
+	Size    int     `json:"size"` // Addresses in the prefix
+	Used    int     `json:"used"`
+	Entries []ipUse `json:"entries,omitempty"`
+}
+
+type ipUse struct {
+	IP        string `json:"IP"`
+	Container string `json:"container"`
+	Source    string `json:"source"` // "interface" or "reservation"
+}
+
+// Operations in progress
+type pendingOperations struct {
+	Requests      []pendingRequest     `json:"requests"`
+	BackgroundOps int                  `json:"backgroundOps"` // Background VSD operations: Mirror expiry, Redirection Target convergence
+	MirrorExpiry  map[string]time.Time `json:"mirrorExpiry"`
+}
+
+// Agent API request being served
+type pendingRequest struct {
+	RequestID string    `json:"requestID"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Client    string    `json:"client,omitempty"`
+	Started   time.Time `json:"started"`
+}
+
+var (
+	// Agent API requests being served
+	pendingRequests = make(map[*http.Request]pendingRequest)
+
+	pendingmutex sync.Mutex
+)
+
+// Dump of the agent internal state. Zones and Subnets are fetched from the VSD
+func getDebugState(w http.ResponseWriter, req *http.Request) {
+	state := &debugState{
+		Time:      time.Now(),
+		Started:   started,
+		VSD:       vsdclient.State(req.Context()),
+		Readiness: checkReadiness(),
+		Listeners: make(map[string]bool),
+		Scopes:    make(map[string]savedScope),
+		Caches: debugCaches{
+			Networks:           make(map[string]nuagecnitypes.NetConf),
+			Containers:         make(map[string]vspk.Container),
+			Interfaces:         make(map[string][]nuagecnitypes.Result),
+			Mirrors:            make(map[string]*vsdclient.Mirror),
+			Reservations:       make(map[string]*vsdclient.Reservation),
+			VIPs:               make(map[string]*vsdclient.VIP),
+			RedirectionTargets: make(map[string]*redirectionTarget),
+			Placements:         make(map[string]*placement.Placement),
+		},
+		Pending: pendingOperations{Requests: []pendingRequest{}, MirrorExpiry: make(map[string]time.Time)},
+	}
+
+	cachemutex.Lock()
+	for name, network := range agent.Networks {
+		state.Caches.Networks[name] = network
+	}
+	for name, container := range agent.Containers {
+		state.Caches.Containers[name] = container
+	}
+	for name, ifaces := range agent.Interfaces {
+		state.Caches.Interfaces[name] = ifaces
+	}
+	cachemutex.Unlock()
+
+	healthmutex.Lock()
+	for l, up := range listeners {
+		state.Listeners[l] = up
+	}
+	state.Pending.BackgroundOps = backgroundcount
+	healthmutex.Unlock()
+
+	scopesmutex.Lock()
+	for name, scope := range containerScopes {
+		state.Scopes[name] = savedScope{Enterprise: scope.enterprise, Zone: scope.zone}
+	}
+	scopesmutex.Unlock()
+
+	placementsmutex.Lock()
+	for name, p := range Placements {
+		state.Caches.Placements[name] = p
+	}
+	placementsmutex.Unlock()
+
+	mirrorsmutex.Lock()
+	for name, mirror := range Mirrors {
+		state.Caches.Mirrors[name] = mirror
+		state.Pending.MirrorExpiry[name] = mirror.Expires
+	}
+	mirrorsmutex.Unlock()
+
+	reservationsmutex.Lock()
+	for name, reservation := range Reservations {
+		state.Caches.Reservations[name] = reservation
+	}
+	reservationsmutex.Unlock()
+
+	vipsmutex.Lock()
+	for name, vip := range VIPs {
+		state.Caches.VIPs[name] = vip
+	}
+	vipsmutex.Unlock()
+
+	redirectionmutex.Lock()
+	for name, rt := range RedirectionTargets {
+		state.Caches.RedirectionTargets[name] = rt
+	}
+	redirectionmutex.Unlock()
+
+	pendingmutex.Lock()
+	for _, pending := range pendingRequests {
+		state.Pending.Requests = append(state.Pending.Requests, pending)
+	}
+	pendingmutex.Unlock()
+	sort.Slice(state.Pending.Requests, func(i, j int) bool {
+		return state.Pending.Requests[i].Started.Before(state.Pending.Requests[j].Started)
+	})
+
+	state.IPAM = ipamUsage(state.VSD, state.Caches.Interfaces, state.Caches.Reservations)
+
+	agent.Sendjson(w, state, http.StatusOK)
+}
+
+// Serve "net/http/pprof" on a given address until it fails. Plain HTTP, unauthenticated
+func servePprof(address string) error {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); (ip == nil && host != "localhost") || (ip != nil && !ip.IsLoopback()) {
+			log.Warningf("!!! pprof admin listener on non-loopback address: %s. Unauthenticated -- restrict access to it !!!", address)
+		}
+	}
+
+	pprofmux := http.NewServeMux()
+	pprofmux.HandleFunc("/debug/pprof/", pprof.Index)
+	pprofmux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	pprofmux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	pprofmux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	pprofmux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Addr: address, Handler: pprofmux}
+	addServer(srv)
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	// XXX - Not one of the agent API listeners, hence not part of the readiness
+	log.Infof("Serving pprof on admin listener: http://%s/debug/pprof/", address)
+	return srv.Serve(l)
+}
+
+////////
+//////// Util
+////////
+
+// Record an agent API request as pending until the returned function is called
+func trackRequest(req *http.Request) func() {
+	pending := pendingRequest{
+		RequestID: trace.RequestID(req.Context()),
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Started:   time.Now(),
+	}
+	if client := requestClient(req); client != nil {
+		pending.Client = client.identity
+	}
+
+	pendingmutex.Lock()
+	pendingRequests[req] = pending
+	pendingmutex.Unlock()
+
+	return func() {
+		pendingmutex.Lock()
+		delete(pendingRequests, req)
+		pendingmutex.Unlock()
+	}
+}
+
+// IP address usage of the known Subnets: Container interface addresses and IP Reservations within each Subnet prefix
+func ipamUsage(vsd *vsdclient.ClientState, interfaces map[string][]nuagecnitypes.Result, reservations map[string]*vsdclient.Reservation) []subnetUsage {
+	usage := []subnetUsage{}
+
+	for _, tenant := range vsd.Tenants {
+		for _, subnet := range tenant.Subnets {
+			ip, mask := net.ParseIP(subnet.Address).To4(), net.ParseIP(subnet.Netmask).To4()
+			if ip == nil || mask == nil {
+				continue
+			}
+			prefix := net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+			ones, bits := prefix.Mask.Size()
+
+			su := subnetUsage{Subnet: subnet.Name, Zone: subnet.Zone, Prefix: prefix.String(), Size: 1 << uint(bits-ones)}
+			for name, results := range interfaces {
+				for _, result := range results {
+					for _, ipc := range result.IPs {
+						if ipc != nil && prefix.Contains(ipc.Address.IP) {
+							su.Entries = append(su.Entries, ipUse{IP: ipc.Address.IP.String(), Container: name, Source: "interface"})
+						}
+					}
+				}
+			}
+			for name, reservation := range reservations {
+				if rip := net.ParseIP(reservation.IPAddress); rip != nil && prefix.Contains(rip) {
+					su.Entries = append(su.Entries, ipUse{IP: reservation.IPAddress, Container: name, Source: "reservation"})
+				}
+			}
+
+			// A reserved address in use by its container is counted once
+			used := make(map[string]bool)
+			for _, entry := range su.Entries {
+				used[entry.IP] = true
+			}
+			su.Used = len(used)
+			sort.Slice(su.Entries, func(i, j int) bool { return su.Entries[i].IP < su.Entries[j].IP })
+
+			usage = append(usage, su)
+		}
+	}
+
+	return usage
+}
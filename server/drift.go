@@ -0,0 +1,334 @@
+package server
+
+////
+//// Drift between the container interface records (CNI Results) and the VSD: Periodic audit, report and (optional) repair of the local records
+////
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-cni/agent/types"
+	nuagecnitypes "github.com/OpenPlatformSDN/nuage-cni/types"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/metrics"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	currentcni "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+const (
+	DriftPath = "/nuage/drift/" // Agent server relative path for the drift report of the container interface records
+
+	driftIdle = time.Minute // How often a disabled audit checks whether it was enabled, e.g. at configuration reload
+
+	driftClient = "agent:drift-audit" // Client of the repairs, in the audit log
+)
+
+// Kinds of drift between a container interface record and the VSD
+const (
+	DriftMissingContainer = "missing-container" // No VSD container with that name
+	DriftMissingInterface = "missing-interface" // No interface on the VSD container, or no IP address in the local record
+	DriftIP               = "ip"
+	DriftMAC              = "mac"
+	DriftSubnet           = "subnet" // Same IP address, different prefix
+)
+
+var driftKinds = []string{DriftMissingContainer, DriftMissingInterface, DriftIP, DriftMAC, DriftSubnet}
+
+// Discrepancy between a container interface record and the VSD
+type Drift struct {
+	Container string `json:"container"`
+	Kind      string `json:"kind"`
+	Local     string `json:"local,omitempty"`
+	VSD       string `json:"vsd,omitempty"`
+	Repaired  bool   `json:"repaired"`
+}
+
+// Outcome of a drift audit
+type DriftReport struct {
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Records  int       `json:"records"` // Container interface records audited
+	Repair   bool      `json:"repair"`
+	Drifts   []Drift   `json:"drifts"`
+	Errors   []string  `json:"errors,omitempty"` // Records that could not be audited, e.g. VSD errors
+}
+
+var driftRepairs = metrics.NewCounterVec("interface_drift_repairs_total", "Container interface records repaired to match the VSD, by kind of drift", "kind")
+
+var (
+	// Last audit. Nil if none yet
+	driftReport *DriftReport
+
+	driftInterval time.Duration
+	driftRepair   bool
+
+	driftmutex sync.Mutex
+)
+
+func init() {
+	metrics.NewGaugeVecFunc("interface_drifts", "Container interface records diverging from the VSD at the last drift audit, by kind of drift", "kind", func() map[string]float64 {
+		counts := make(map[string]float64)
+		for _, kind := range driftKinds {
+			counts[kind] = 0
+		}
+
+		driftmutex.Lock()
+		defer driftmutex.Unlock()
+		if driftReport != nil {
+			for _, drift := range driftReport.Drifts {
+				counts[drift.Kind]++
+			}
+		}
+		return counts
+	})
+
+	metrics.NewGaugeFunc("interface_drift_audit_timestamp_seconds", "Start time of the last drift audit, as a Unix timestamp. 0 if none yet", func() float64 {
+		driftmutex.Lock()
+		defer driftmutex.Unlock()
+		if driftReport == nil {
+			return 0
+		}
+		return float64(driftReport.Started.Unix())
+	})
+}
+
+// Last drift report
+func getDriftReport(w http.ResponseWriter, req *http.Request) {
+	driftmutex.Lock()
+	report := driftReport
+	driftmutex.Unlock()
+
+	if report == nil {
+		agent.Sendjson(w, &DriftReport{Drifts: []Drift{}}, http.StatusOK)
+		return
+	}
+	agent.Sendjson(w, report, http.StatusOK)
+}
+
+// Set the audit interval and whether to repair the local records, e.g. at configuration reload
+func setDrift(conf *config.Config) {
+	driftmutex.Lock()
+	driftInterval = time.Duration(conf.Drift.Interval) * time.Second
+	driftRepair = conf.Drift.Repair
+	driftmutex.Unlock()
+}
+
+// Periodically audit the container interface records, as configured. Does not return.
+func auditDrift() {
+	for {
+		driftmutex.Lock()
+		interval, repair := driftInterval, driftRepair
+		driftmutex.Unlock()
+
+		if interval == 0 {
+			time.Sleep(driftIdle)
+			continue
+		}
+
+		time.Sleep(interval)
+		background(func() {
+			report := auditInterfaces(context.Background(), repair)
+
+			driftmutex.Lock()
+			driftReport = report
+			driftmutex.Unlock()
+
+			if len(report.Drifts) > 0 || len(report.Errors) > 0 {
+				log.Warningf("Drift audit: %d drift(s) from the VSD in %d container interface record(s), %d could not be audited. Details at: %s", len(report.Drifts), report.Records, len(report.Errors), DriftPath)
+			} else {
+				log.Infof("Drift audit: All %d container interface record(s) match the VSD", report.Records)
+			}
+		})
+	}
+}
+
+////////
+//////// Util
+////////
+
+// Compare each container interface record with the VSD container and its (first) interface. Optionally repair the local records
+// XXX - "cachemutex" is held for one record at a time -- VSD calls included -- so agent API requests are served in between
+func auditInterfaces(ctx context.Context, repair bool) *DriftReport {
+	report := &DriftReport{Started: time.Now(), Repair: repair, Drifts: []Drift{}}
+
+	var names []string
+	cachemutex.Lock()
+	for name := range agent.Interfaces {
+		names = append(names, name)
+	}
+	cachemutex.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		auditInterface(ctx, name, repair, report)
+	}
+
+	report.Duration = time.Since(report.Started).Truncate(time.Millisecond).String()
+	return report
+}
+
+// Compare -- and optionally repair -- the interface record of a given container, if still cached
+func auditInterface(ctx context.Context, name string, repair bool, report *DriftReport) {
+	cachemutex.Lock()
+	defer cachemutex.Unlock()
+
+	results, exists := agent.Interfaces[name]
+	if !exists {
+		return
+	}
+	report.Records++
+
+	container := &vsdclient.Container{Name: name}
+	if err := container.FetchByName(ctx); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+
+	if container.ID == "" {
+		drift := Drift{Container: name, Kind: DriftMissingContainer}
+
+		// A container seen missing once may be (re-)created meanwhile. Only repaired when still missing at the next audit
+		if repair && missingBefore(name) {
+			// Same as deleting the container interfaces through the agent API: The container is gone
+			auditedChange(ctx, driftClient, "DELETE", types.ResultPath+name, ResourceInterfaces, name, func(ctx context.Context) {
+				before := snapshots[ResourceInterfaces](name)
+				delete(agent.Interfaces, name)
+				emitEvent(ResourceInterfaces, name, before, "")
+				for _, cleanup := range containerCleanups {
+					cleanup(ctx, name)
+				}
+			})
+			log.Warningf("Removed interface record of Container: %s. Container missing from the VSD at two consecutive audits", name)
+			drift.Repaired = true
+			driftRepairs.Inc(drift.Kind)
+		}
+		report.Drifts = append(report.Drifts, drift)
+		return
+	}
+
+	cifaces, err := container.FetchInterfaces(ctx)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+
+	ipc, iface := localAddressing(results)
+	if len(cifaces) == 0 || ipc == nil {
+		report.Drifts = append(report.Drifts, Drift{Container: name, Kind: DriftMissingInterface})
+		return
+	}
+
+	drifts := compareInterface(name, ipc, iface, cifaces[0])
+	if repair && len(drifts) > 0 {
+		var err error
+		auditedChange(ctx, driftClient, "PUT", types.ResultPath+name, ResourceInterfaces, name, func(context.Context) { err = repairInterface(name, cifaces[0]) })
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("Cannot repair interface record of Container: %s. Error: %s", name, err))
+		} else {
+			for i := range drifts {
+				drifts[i].Repaired = true
+				driftRepairs.Inc(drifts[i].Kind)
+			}
+		}
+	}
+	report.Drifts = append(report.Drifts, drifts...)
+}
+
+// Whether a given container was found missing from the VSD at the previous audit
+func missingBefore(name string) bool {
+	driftmutex.Lock()
+	defer driftmutex.Unlock()
+
+	if driftReport == nil {
+		return false
+	}
+	for _, drift := range driftReport.Drifts {
+		if drift.Container == name && drift.Kind == DriftMissingContainer {
+			return true
+		}
+	}
+	return false
+}
+
+// First IP address of a container interface record, and the interface it is on (if known)
+func localAddressing(results []nuagecnitypes.Result) (*currentcni.IPConfig, *currentcni.Interface) {
+	for _, result := range results {
+		for _, ipc := range result.IPs {
+			if ipc == nil {
+				continue
+			}
+			if ipc.Interface >= 0 && ipc.Interface < len(result.Interfaces) {
+				return ipc, result.Interfaces[ipc.Interface]
+			}
+			return ipc, nil
+		}
+	}
+	return nil, nil
+}
+
+func compareInterface(name string, ipc *currentcni.IPConfig, iface *currentcni.Interface, ciface *vspk.ContainerInterface) []Drift {
+	var drifts []Drift
+
+	vsdip, vsdmask := net.ParseIP(ciface.IPAddress), net.ParseIP(ciface.Netmask).To4()
+	switch {
+	case vsdip == nil || !vsdip.Equal(ipc.Address.IP):
+		drifts = append(drifts, Drift{Container: name, Kind: DriftIP, Local: ipc.Address.IP.String(), VSD: ciface.IPAddress})
+	case vsdmask != nil:
+		vsdprefix := net.IPNet{IP: vsdip.Mask(net.IPMask(vsdmask)), Mask: net.IPMask(vsdmask)}
+		localprefix := net.IPNet{IP: ipc.Address.IP.Mask(ipc.Address.Mask), Mask: ipc.Address.Mask}
+		if vsdprefix.String() != localprefix.String() {
+			drifts = append(drifts, Drift{Container: name, Kind: DriftSubnet, Local: localprefix.String(), VSD: vsdprefix.String()})
+		}
+	}
+
+	if iface != nil && ciface.MAC != "" && !strings.EqualFold(iface.Mac, ciface.MAC) {
+		drifts = append(drifts, Drift{Container: name, Kind: DriftMAC, Local: iface.Mac, VSD: ciface.MAC})
+	}
+
+	return drifts
+}
+
+// Set the IP address, prefix and MAC address of the VSD container interface in the local record
+// XXX - Callers must hold "cachemutex"
+func repairInterface(name string, ciface *vspk.ContainerInterface) error {
+	ip, mask := net.ParseIP(ciface.IPAddress), net.ParseIP(ciface.Netmask).To4()
+	if ip == nil || mask == nil {
+		return fmt.Errorf("Invalid VSD container interface addressing: %s/%s", ciface.IPAddress, ciface.Netmask)
+	}
+
+	// Repair a copy, so the record is replaced as a whole
+	var results []nuagecnitypes.Result
+	data, err := json.Marshal(agent.Interfaces[name])
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		return err
+	}
+
+	ipc, iface := localAddressing(results)
+	if ipc == nil {
+		return fmt.Errorf("No IP address in the local record")
+	}
+	ipc.Address = net.IPNet{IP: ip.To4(), Mask: net.IPMask(mask)}
+	if iface != nil && ciface.MAC != "" {
+		iface.Mac = ciface.MAC
+	}
+
+	before := snapshots[ResourceInterfaces](name)
+	agent.Interfaces[name] = results
+	emitEvent(ResourceInterfaces, name, before, snapshots[ResourceInterfaces](name))
+
+	log.Warningf("Repaired interface record of Container: %s to match the VSD: IP: %s/%s, MAC: %s", name, ciface.IPAddress, ciface.Netmask, ciface.MAC)
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+// Error titles for the locally defined agent server resources. Same conventions as "nuage-cni/errors"
+const (
+	////
+	//// Mirror Errors
+	////
+	MirrorNotFound     = "Cannot find Mirror: "
+	MirrorCannotCreate = "Cannot create Mirror: "
+	MirrorCannotDelete = "Cannot delete Mirror: "
+
+	////
+	//// IP Reservation Errors
+	////
+	ReservationNotFound     = "Cannot find IP Reservation: "
+	ReservationCannotCreate = "Cannot create IP Reservation: "
+	ReservationCannotDelete = "Cannot delete IP Reservation: "
+
+	////
+	//// VIP Errors
+	////
+	VIPNotFound     = "Cannot find VIP: "
+	VIPCannotCreate = "Cannot create VIP: "
+	VIPCannotMove   = "Cannot move VIP: "
+	VIPCannotDelete = "Cannot delete VIP: "
+
+	////
+	//// Redirection Target Errors
+	////
+	RedirectionTargetNotFound     = "Cannot find Redirection Target: "
+	RedirectionTargetCannotCreate = "Cannot create Redirection Target: "
+	RedirectionTargetCannotDelete = "Cannot delete Redirection Target: "
+
+	////
+	//// Logging Errors
+	////
+	LogLevelCannotSet = "Cannot set log level of subsystem: "
+
+	////
+	//// Event Errors
+	////
+	EventsCannotWatch = "Cannot watch agent cache events"
+
+	////
+	//// Audit Errors
+	////
+	AuditCannotRecord = "Cannot record change in the audit log: "
+
+	////
+	//// Client Errors
+	////
+	ClientNotAllowed = "Client not allowed: "
+	ActionDenied     = "Action denied: "
+)
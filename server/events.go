@@ -0,0 +1,300 @@
+package server
+
+////
+//// Event stream of the changes to the agent caches: Networks, Containers and Container Interfaces.
+//// Served as server-sent events, or as a long-poll watch, with resume from the last seen version
+////
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+const (
+	EventsPath = "/nuage/events/" // Agent server relative path for the event stream of the agent caches
+
+	// Header carrying the current version of the agent caches in the list responses, i.e. the version to watch from
+	ResourceVersionHeader = "X-Resource-Version"
+)
+
+// Event types
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+	EventResync  = "resync" // Events were missed (e.g. agent restart, client too far behind): Re-list, then watch from the returned version
+)
+
+// Resources with change events
+var eventResources = []string{ResourceNetworks, ResourceContainers, ResourceInterfaces}
+
+const (
+	eventBacklog     = 1024             // Events kept for resuming
+	keepaliveTimeout = 15 * time.Second // Idle time between server-sent event keepalives
+	defaultWait      = 30 * time.Second // Long-poll watch
+	maxWait          = 5 * time.Minute
+)
+
+// Change to an object of the agent caches
+type Event struct {
+	Version  uint64      `json:"version"`
+	Type     string      `json:"type"`
+	Resource string      `json:"resource,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Time     time.Time   `json:"time"`
+	Object   interface{} `json:"object,omitempty"` // Current object. Absent for deletions
+}
+
+// Long-poll watch response
+type watchResult struct {
+	Version uint64   `json:"version"` // Version to watch from next
+	Events  []*Event `json:"events"`
+}
+
+var (
+	// Latest events, oldest first
+	events []*Event
+
+	// Version of the latest event. Starts from the agent start time, so versions keep increasing across agent restarts
+	// XXX - Fits within 2^53 (i.e. JSON numbers in JavaScript clients) until year 2255, at up to 2^20 events per second of agent downtime
+	eventVersion = uint64(time.Now().Unix()) << 20
+
+	// Closed -- and replaced -- at every new event, waking up the watchers
+	eventSignal = make(chan struct{})
+
+	// Closed at shutdown, ending the event streams
+	eventsClosed = make(chan struct{})
+
+	eventsmutex sync.Mutex
+)
+
+// Stream the events after a given version ("since" query parameter or "Last-Event-ID" header. Default: current version),
+// optionally restricted to some resources (e.g. "resources=containers,interfaces").
+// Server-sent events if the client accepts "text/event-stream", otherwise a long-poll watch returning once there are events or after "wait" (e.g. "30s")
+func getEvents(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	since := query.Get("since")
+	if since == "" {
+		since = req.Header.Get("Last-Event-ID")
+	}
+	version := currentVersion()
+	if since != "" {
+		v, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			agent.Sendjson(w, bambou.NewBambouError(EventsCannotWatch, "Invalid version: "+since), http.StatusBadRequest)
+			return
+		}
+		version = v
+	}
+
+	resources := make(map[string]bool)
+	if query.Get("resources") != "" {
+		for _, resource := range strings.Split(query.Get("resources"), ",") {
+			if !contains(eventResources, resource) {
+				agent.Sendjson(w, bambou.NewBambouError(EventsCannotWatch, "Invalid resource: "+resource+". Valid resources are: "+strings.Join(eventResources, ", ")), http.StatusBadRequest)
+				return
+			}
+			resources[resource] = true
+		}
+	}
+
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		streamEvents(w, req, version, resources)
+		return
+	}
+
+	wait := defaultWait
+	if query.Get("wait") != "" {
+		d, err := time.ParseDuration(query.Get("wait"))
+		if err != nil || d < 0 || d > maxWait {
+			agent.Sendjson(w, bambou.NewBambouError(EventsCannotWatch, fmt.Sprintf("Invalid wait: %s. Valid waits are durations up to: %s", query.Get("wait"), maxWait)), http.StatusBadRequest)
+			return
+		}
+		wait = d
+	}
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		batch, next, signal := eventsSince(version, resources)
+		if len(batch) > 0 {
+			agent.Sendjson(w, &watchResult{Version: next, Events: batch}, http.StatusOK)
+			return
+		}
+		version = next
+
+		select {
+		case <-signal:
+		case <-timeout.C:
+			agent.Sendjson(w, &watchResult{Version: version, Events: []*Event{}}, http.StatusOK)
+			return
+		case <-req.Context().Done():
+			return
+		case <-eventsClosed:
+			agent.Sendjson(w, &watchResult{Version: version, Events: []*Event{}}, http.StatusOK)
+			return
+		}
+	}
+}
+
+func streamEvents(w http.ResponseWriter, req *http.Request, version uint64, resources map[string]bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		agent.Sendjson(w, bambou.NewBambouError(EventsCannotWatch, "Streaming not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	log.Infof("Streaming events after version: %d to client -- %s", version, req.RemoteAddr)
+
+	keepalive := time.NewTicker(keepaliveTimeout)
+	defer keepalive.Stop()
+	for {
+		batch, next, signal := eventsSince(version, resources)
+		for _, event := range batch {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Version, event.Type, data)
+		}
+		if len(batch) > 0 {
+			flusher.Flush()
+		}
+		version = next
+
+		select {
+		case <-signal:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-eventsClosed:
+			return
+		}
+	}
+}
+
+// Record the change of a cached object, if any, given its digest before and after the change
+// XXX - Callers must hold "cachemutex"
+func emitEvent(resource, name, before, after string) {
+	if before == after {
+		return
+	}
+
+	event := &Event{Type: EventUpdated, Resource: resource, Name: name, Time: time.Now()}
+	switch {
+	case before == "":
+		event.Type = EventCreated
+	case after == "":
+		event.Type = EventDeleted
+	}
+	if event.Type != EventDeleted {
+		event.Object = cachedObject(resource, name)
+	}
+
+	eventsmutex.Lock()
+	eventVersion++
+	event.Version = eventVersion
+	events = append(events, event)
+	if len(events) > eventBacklog {
+		events = events[len(events)-eventBacklog:]
+	}
+	close(eventSignal)
+	eventSignal = make(chan struct{})
+	eventsmutex.Unlock()
+
+	log.Debugf("Event: %d %s %s: %s", event.Version, event.Type, resource, name)
+}
+
+// Emit the change events of an agent cache change
+func watched(resource string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+		if name == "" && resource == ResourceNetworks {
+			name = networkName(req)
+		}
+
+		before := snapshots[resource](name)
+		handler(w, req)
+		emitEvent(resource, name, before, snapshots[resource](name))
+	}
+}
+
+// Set the current version of the agent caches in the response of a list handler
+func versioned(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(ResourceVersionHeader, strconv.FormatUint(currentVersion(), 10))
+		handler(w, req)
+	}
+}
+
+////////
+//////// Util
+////////
+
+func currentVersion() uint64 {
+	eventsmutex.Lock()
+	defer eventsmutex.Unlock()
+	return eventVersion
+}
+
+// Events after a given version, the version to continue from and the signal of the next event.
+// A single "resync" event if the events after that version are no longer (or not yet) known
+func eventsSince(version uint64, resources map[string]bool) ([]*Event, uint64, chan struct{}) {
+	eventsmutex.Lock()
+	defer eventsmutex.Unlock()
+
+	oldest := eventVersion + 1
+	if len(events) > 0 {
+		oldest = events[0].Version
+	}
+	if version > eventVersion || version+1 < oldest {
+		return []*Event{{Version: eventVersion, Type: EventResync, Time: time.Now()}}, eventVersion, eventSignal
+	}
+
+	var batch []*Event
+	for _, event := range events {
+		if event.Version > version && (len(resources) == 0 || resources[event.Resource]) {
+			batch = append(batch, event)
+		}
+	}
+	return batch, eventVersion, eventSignal
+}
+
+// End the event streams and long-poll watches, e.g. at shutdown
+func closeEvents() {
+	eventsmutex.Lock()
+	defer eventsmutex.Unlock()
+
+	select {
+	case <-eventsClosed:
+	default:
+		close(eventsClosed)
+	}
+}
+
+// XXX - Callers must hold "cachemutex"
+func cachedObject(resource, name string) interface{} {
+	switch resource {
+	case ResourceNetworks:
+		return agent.Networks[name]
+	case ResourceContainers:
+		return agent.Containers[name]
+	case ResourceInterfaces:
+		return agent.Interfaces[name]
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package server
+
+////
+//// Health (process alive) and readiness (agent actually usable) of the agent, for node supervisors
+////
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+)
+
+const (
+	HealthPath    = "/healthz" // Agent server relative path for the liveness check
+	ReadinessPath = "/readyz"  // Agent server relative path for the readiness check
+)
+
+// Result of a readiness check
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	Ready  bool   `json:"ready"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Readiness of the agent, with the detail of every check
+type Readiness struct {
+	Ready  bool                   `json:"ready"`
+	Checks []ReadinessCheck       `json:"checks"`
+	VSD    vsdclient.SessionState `json:"vsd"`
+}
+
+var (
+	started = time.Now()
+
+	// Whether the local state was loaded: IP reservations from the VSD, plus the saved state (if any)
+	stateLoaded bool
+
+	// Listeners currently serving. Key: Listener address, e.g. "tcp::7443" or "unix:/run/nuage-oci-agent.sock"
+	listeners = make(map[string]bool)
+
+	healthmutex sync.Mutex
+)
+
+// Liveness: The process is up and serving requests
+func getHealth(w http.ResponseWriter, req *http.Request) {
+	agent.Sendjson(w, struct {
+		Status  string `json:"status"`
+		Started string `json:"started"`
+		Uptime  string `json:"uptime"`
+	}{
+		Status:  "ok",
+		Started: started.UTC().Format(time.RFC3339),
+		Uptime:  time.Since(started).Truncate(time.Second).String(),
+	}, http.StatusOK)
+}
+
+// Readiness: VSD session established, tenants resolved, local state loaded and listeners serving
+func getReadiness(w http.ResponseWriter, req *http.Request) {
+	readiness := checkReadiness()
+	if readiness.Ready {
+		agent.Sendjson(w, readiness, http.StatusOK)
+	} else {
+		agent.Sendjson(w, readiness, http.StatusServiceUnavailable)
+	}
+}
+
+func checkReadiness() *Readiness {
+	session := vsdclient.Session()
+	readiness := &Readiness{VSD: session}
+
+	vsdcheck := ReadinessCheck{Name: "vsd-session", Ready: session.Up && !session.Reconnecting}
+	switch {
+	case session.Reconnecting:
+		vsdcheck.Detail = "Re-establishing the VSD session"
+	case !session.Up:
+		vsdcheck.Detail = "No VSD session"
+	}
+
+	tenantcheck := ReadinessCheck{Name: "vsd-tenants", Ready: session.Tenants > 0 && !session.Reconnecting}
+	if !tenantcheck.Ready {
+		tenantcheck.Detail = "VSD Enterprise and Domain (or L2Domain) not resolved"
+	}
+
+	healthmutex.Lock()
+	statecheck := ReadinessCheck{Name: "state-store", Ready: stateLoaded}
+	if !stateLoaded {
+		statecheck.Detail = "Local state not loaded"
+	}
+
+	drainingcheck := ReadinessCheck{Name: "shutdown", Ready: !draining}
+	if draining {
+		drainingcheck.Detail = "Shutting down"
+	}
+
+	var serving []string
+	for l, up := range listeners {
+		if up {
+			serving = append(serving, l)
+		}
+	}
+	healthmutex.Unlock()
+
+	sort.Strings(serving)
+	listenercheck := ReadinessCheck{Name: "listeners", Ready: len(serving) > 0}
+	if len(serving) > 0 {
+		listenercheck.Detail = "Serving on: " + strings.Join(serving, ", ")
+	} else {
+		listenercheck.Detail = "No listener serving"
+	}
+
+	readiness.Checks = []ReadinessCheck{vsdcheck, tenantcheck, statecheck, listenercheck, drainingcheck}
+	readiness.Ready = true
+	for _, check := range readiness.Checks {
+		readiness.Ready = readiness.Ready && check.Ready
+	}
+
+	return readiness
+}
+
+// Serve the health and readiness checks on a listener of their own, for node supervisors probing the agent without a client certificate
+func serveHealth(address string) error {
+	healthmux := http.NewServeMux()
+	healthmux.HandleFunc(HealthPath, getHealth)
+	healthmux.HandleFunc(ReadinessPath, getReadiness)
+
+	srv := &http.Server{Addr: address, Handler: healthmux}
+	addServer(srv)
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Errorf("Cannot listen on health listener: %s. Error: %s", address, err)
+		return err
+	}
+
+	// XXX - Not one of the agent API listeners, hence not part of the readiness
+	log.Infof("Serving health and readiness checks on: http://%s%s, http://%s%s", address, HealthPath, address, ReadinessPath)
+	return srv.Serve(l)
+}
+
+////////
+//////// Util
+////////
+
+func setStateLoaded(loaded bool) {
+	healthmutex.Lock()
+	stateLoaded = loaded
+	healthmutex.Unlock()
+}
+
+// Record whether a given listener is serving
+func setListening(address string, up bool) {
+	healthmutex.Lock()
+	listeners[address] = up
+	healthmutex.Unlock()
+}
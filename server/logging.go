@@ -0,0 +1,49 @@
+package server
+
+////
+//// Agent server logging, plus the runtime control of the log levels by subsystem
+////
+
+import (
+	"encoding/json"
+	"net/http"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+const (
+	LogLevelPath = "/nuage/loglevels/" // Agent server relative path for the log levels, by subsystem
+)
+
+var log = logging.For(logging.Server)
+
+// List the log levels of all the subsystems
+func getLogLevels(w http.ResponseWriter, req *http.Request) {
+	agent.Sendjson(w, logging.Levels(), http.StatusOK)
+}
+
+// Change the log level of a given subsystem, until the next configuration reload or restart
+func putLogLevel(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	var level struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&level); err != nil {
+		log.Errorf("Log level change request - JSON decoding error: %s", err)
+		agent.Sendjson(w, bambou.NewBambouError(LogLevelCannotSet+vars["name"], "JSON decoding error"), http.StatusBadRequest)
+		return
+	}
+
+	if err := logging.SetLevel(vars["name"], level.Level); err != nil {
+		log.Errorf("Log level change request error: %s", err)
+		agent.Sendjson(w, bambou.NewBambouError(LogLevelCannotSet+vars["name"], err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("Log level of subsystem: %s set to: %s", vars["name"], level.Level)
+	agent.Sendjson(w, logging.Levels(), http.StatusOK)
+}
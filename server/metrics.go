@@ -0,0 +1,99 @@
+package server
+
+////
+//// Agent API metrics: Requests by route and status, cache sizes and container validation failures
+////
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/metrics"
+	"github.com/gorilla/mux"
+)
+
+const (
+	MetricsPath = "/metrics" // Agent server relative path for the Prometheus metrics
+)
+
+var (
+	httpRequests       = metrics.NewCounterVec("http_requests_total", "Agent API requests, by route, method and status", "route", "method", "status")
+	httpLatency        = metrics.NewHistogramVec("http_request_duration_seconds", "Agent API request latency, by route and method", metrics.DefBuckets, "route", "method")
+	validationFailures = metrics.NewCounterVec("container_validation_failures_total", "Rejected Container create requests, by reason", "reason")
+)
+
+func init() {
+	metrics.NewGaugeVecFunc("cache_entries", "Number of cached objects, by cache", "cache", func() map[string]float64 {
+		cachemutex.Lock()
+		sizes := map[string]float64{
+			ResourceContainers: float64(len(agent.Containers)),
+			ResourceNetworks:   float64(len(agent.Networks)),
+			ResourceInterfaces: float64(len(agent.Interfaces)),
+		}
+		cachemutex.Unlock()
+
+		mirrorsmutex.Lock()
+		sizes[ResourceMirrors] = float64(len(Mirrors))
+		mirrorsmutex.Unlock()
+
+		reservationsmutex.Lock()
+		sizes[ResourceReservations] = float64(len(Reservations))
+		reservationsmutex.Unlock()
+
+		vipsmutex.Lock()
+		sizes[ResourceVIPs] = float64(len(VIPs))
+		vipsmutex.Unlock()
+
+		redirectionmutex.Lock()
+		sizes[ResourceRedirectionTargets] = float64(len(RedirectionTargets))
+		redirectionmutex.Unlock()
+
+		return sizes
+	})
+}
+
+// Container metadata validation error, with the reason it is reported under in the metrics
+type validationError struct {
+	reason string
+	err    error
+}
+
+func (ve *validationError) Error() string {
+	return ve.err.Error()
+}
+
+func invalid(reason, format string, args ...interface{}) error {
+	return &validationError{reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+// Count a rejected Container create request
+func validationFailed(err error) {
+	reason := "other"
+	if ve, ok := err.(*validationError); ok {
+		reason = ve.reason
+	}
+	validationFailures.Inc(reason)
+}
+
+////////
+//////// Util
+////////
+
+// Record an agent API request, by route
+func observeRequest(req *http.Request, rec *statusRecorder, start time.Time) {
+	route := routeTemplate(req)
+	httpRequests.Inc(route, req.Method, strconv.Itoa(rec.status))
+	httpLatency.Observe(time.Since(start).Seconds(), route, req.Method)
+}
+
+// Path template of the route of a request, e.g. "/nuage/mirrors/{name}" -- to keep the metric label cardinality bounded
+// XXX - This version of "gorilla/mux" does not expose the path template of a route, so routes are named after it, see "handleRoute"
+func routeTemplate(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil && route.GetName() != "" {
+		return route.GetName()
+	}
+	return "other"
+}
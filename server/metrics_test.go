@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRouteTemplate(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string // Empty: Route registered without "handleRoute"
+		path  string
+		want  string
+	}{
+		{name: "no variables", route: MirrorPath, path: MirrorPath, want: MirrorPath},
+		{name: "variable", route: MirrorPath + "{name}", path: MirrorPath + "c1", want: MirrorPath + "{name}"},
+		{name: "variable equal to a path segment", route: "/nuage/containers/{name}", path: "/nuage/containers/containers", want: "/nuage/containers/{name}"},
+		{name: "variable equal to another one", route: "/nuage/{kind}/{name}", path: "/nuage/c1/c1", want: "/nuage/{kind}/{name}"},
+		{name: "unnamed route", path: "/nuage/containers/c1", want: "other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+			handler := func(w http.ResponseWriter, req *http.Request) { got = routeTemplate(req) }
+
+			router := mux.NewRouter()
+			if test.route != "" {
+				handleRoute(router, test.route, handler)
+			} else {
+				router.HandleFunc("/nuage/containers/{name}", handler)
+			}
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.path, nil))
+			if got != test.want {
+				t.Errorf("routeTemplate(%s) = %q, want %q", test.path, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+////
+//// Port mirroring of container traffic, for debugging purposes
+////
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+const (
+	MirrorPath = "/nuage/mirrors/" // Agent server relative path for container port mirroring
+
+	defaultMirrorExpiry = 1 * time.Hour   // Mirrors without explicit expiry are torn down after this interval
+	mirrorRetry         = 1 * time.Minute // Mirrors that could not be torn down are retried after this interval
+)
+
+var (
+	// Active port mirrors
+	// Key: Container Name
+	Mirrors = make(map[string]*vsdclient.Mirror)
+
+	// Expiry timers of active port mirrors. Same key as "Mirrors"
+	mirrortimers = make(map[string]*time.Timer)
+
+	mirrorsmutex sync.Mutex
+
+	// Serialize the changes -- tear down, create and record -- of the mirror of a given container
+	mirrorlocks = newNameLocks()
+)
+
+func init() {
+	// Tear down port mirroring when the mirrored container goes away
+	containerCleanups = append(containerCleanups, func(ctx context.Context, name string) { removeMirror(ctx, name) })
+}
+
+// Create a port mirror for a given container name. Any existing mirror for that container is replaced
+func putMirror(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	mirror := vsdclient.Mirror{}
+	if err := json.NewDecoder(req.Body).Decode(&mirror); err != nil {
+		log.Errorf("Mirror create request - JSON decoding error: %s", err)
+		agent.Sendjson(w, bambou.NewBambouError(MirrorCannotCreate+vars["name"], "JSON decoding error"), http.StatusBadRequest)
+		return
+	}
+	mirror.Container = vars["name"]
+
+	if mirror.Destination == "" {
+		log.Errorf("Mirror create request error: No mirror destination for Container: %s", mirror.Container)
+		agent.Sendjson(w, bambou.NewBambouError(MirrorCannotCreate+mirror.Container, "Mirror destination is missing"), http.StatusBadRequest)
+		return
+	}
+
+	if !mirror.Overlay {
+		switch mirror.Direction {
+		case "":
+			mirror.Direction = vsdclient.MirrorBoth
+		case vsdclient.MirrorIngress, vsdclient.MirrorEgress, vsdclient.MirrorBoth:
+		default:
+			log.Errorf("Mirror create request error: Invalid mirror direction: %s", mirror.Direction)
+			agent.Sendjson(w, bambou.NewBambouError(MirrorCannotCreate+mirror.Container, "Invalid mirror direction: "+mirror.Direction), http.StatusBadRequest)
+			return
+		}
+	}
+
+	expiry := defaultMirrorExpiry
+	if mirror.Expiry != "" {
+		var err error
+		if expiry, err = time.ParseDuration(mirror.Expiry); err != nil || expiry <= 0 {
+			log.Errorf("Mirror create request error: Invalid mirror expiry: %s", mirror.Expiry)
+			agent.Sendjson(w, bambou.NewBambouError(MirrorCannotCreate+mirror.Container, "Invalid mirror expiry: "+mirror.Expiry), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mirrorlocks.Lock(mirror.Container)
+	defer mirrorlocks.Unlock(mirror.Container)
+
+	// Replace any existing mirror for this container
+	if err := tearDownMirror(req.Context(), mirror.Container); err != nil {
+		agent.Sendjson(w, bambou.NewBambouError(MirrorCannotCreate+mirror.Container, err.Error()), http.StatusConflict)
+		return
+	}
+
+	if err := mirror.Create(req.Context()); err != nil {
+		log.Errorf("Mirror create request error: %s", err)
+		agent.Sendjson(w, err, http.StatusConflict)
+		return
+	}
+	auditVSD(req.Context(), "Created Mirror of VPort: %s to: %s", mirror.VPortID, mirror.Destination)
+
+	mirror.Expires = time.Now().Add(expiry)
+
+	mirrorsmutex.Lock()
+	Mirrors[mirror.Container] = &mirror
+	mirrortimers[mirror.Container] = expireMirror(&mirror, expiry)
+	mirrorsmutex.Unlock()
+
+	log.Infof("Successfully created Mirror for Container: %s, expires at: %s", mirror.Container, mirror.Expires)
+	agent.Sendjson(w, mirror, http.StatusCreated)
+}
+
+// List all active mirrors
+func getMirrors(w http.ResponseWriter, req *http.Request) {
+	log.Info("Serving list of active Mirrors")
+	mirrorsmutex.Lock()
+	defer mirrorsmutex.Unlock()
+
+	var resp []vsdclient.Mirror
+	for _, mirror := range Mirrors {
+		resp = append(resp, *mirror)
+	}
+	agent.Sendjson(w, resp, http.StatusOK)
+}
+
+// Get the mirror of a given container
+func getMirror(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	mirrorsmutex.Lock()
+	defer mirrorsmutex.Unlock()
+
+	if mirror, exists := Mirrors[vars["name"]]; exists {
+		log.Infof("Serving Mirror for Container: %s", vars["name"])
+		agent.Sendjson(w, mirror, http.StatusOK)
+	} else {
+		log.Warningf("Cannot find Mirror for Container: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(MirrorNotFound+vars["name"], ""), http.StatusNotFound)
+	}
+}
+
+// Tear down the mirror of a given container
+func deleteMirror(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	mirrorsmutex.Lock()
+	_, exists := Mirrors[vars["name"]]
+	mirrorsmutex.Unlock()
+
+	if !exists {
+		log.Warningf("Cannot find Mirror for Container: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(MirrorNotFound+vars["name"], ""), http.StatusNotFound)
+		return
+	}
+
+	if err := removeMirror(req.Context(), vars["name"]); err != nil {
+		agent.Sendjson(w, bambou.NewBambouError(MirrorCannotDelete+vars["name"], err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	agent.Sendjson(w, nil, http.StatusOK)
+}
+
+////////
+//////// Util
+////////
+
+// Tear down a given mirror after a given interval -- unless it was replaced or removed meanwhile
+func expireMirror(mirror *vsdclient.Mirror, after time.Duration) *time.Timer {
+	return time.AfterFunc(after, func() {
+		background(func() {
+			mirrorlocks.Lock(mirror.Container)
+			defer mirrorlocks.Unlock(mirror.Container)
+
+			mirrorsmutex.Lock()
+			current := Mirrors[mirror.Container] == mirror
+			mirrorsmutex.Unlock()
+
+			if !current {
+				return
+			}
+
+			log.Infof("Mirror for Container: %s expired", mirror.Container)
+			tearDownMirror(context.Background(), mirror.Container)
+		})
+	})
+}
+
+// Remove the mirror of a given container (if any) from both the VSD and the local cache.
+// If the VSD mirror cannot be removed, the local record is kept and the removal retried later
+func removeMirror(ctx context.Context, name string) error {
+	mirrorlocks.Lock(name)
+	defer mirrorlocks.Unlock(name)
+
+	return tearDownMirror(ctx, name)
+}
+
+// Same as "removeMirror"
+// XXX - Callers must hold the lock of "name" in "mirrorlocks"
+func tearDownMirror(ctx context.Context, name string) error {
+	mirrorsmutex.Lock()
+	mirror, exists := Mirrors[name]
+	if exists {
+		mirrortimers[name].Stop()
+	}
+	mirrorsmutex.Unlock()
+
+	if !exists {
+		return nil
+	}
+
+	if err := mirror.Delete(ctx); err != nil {
+		log.Errorf("Failed to remove Mirror for Container: %s. Retrying in: %s. Error: %s", name, mirrorRetry, err)
+		mirrorsmutex.Lock()
+		if Mirrors[name] == mirror {
+			mirrortimers[name] = expireMirror(mirror, mirrorRetry)
+		}
+		mirrorsmutex.Unlock()
+		return err
+	}
+	auditVSD(ctx, "Deleted Mirror of VPort: %s to: %s", mirror.VPortID, mirror.Destination)
+
+	mirrorsmutex.Lock()
+	if Mirrors[name] == mirror {
+		delete(Mirrors, name)
+		delete(mirrortimers, name)
+	}
+	mirrorsmutex.Unlock()
+
+	log.Infof("Successfully removed Mirror for Container: %s", name)
+	return nil
+}
+
+// Per-name locks, e.g. to serialize changes made of several VSD calls for a given container.
+// Unused locks are dropped
+type nameLocks struct {
+	mutex sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	sync.Mutex
+	users int // Holders and waiters
+}
+
+func newNameLocks() *nameLocks {
+	return &nameLocks{locks: make(map[string]*nameLock)}
+}
+
+func (nl *nameLocks) Lock(name string) {
+	nl.mutex.Lock()
+	lock, exists := nl.locks[name]
+	if !exists {
+		lock = &nameLock{}
+		nl.locks[name] = lock
+	}
+	lock.users++
+	nl.mutex.Unlock()
+
+	lock.Lock()
+}
+
+func (nl *nameLocks) Unlock(name string) {
+	nl.mutex.Lock()
+	defer nl.mutex.Unlock()
+
+	lock := nl.locks[name]
+	lock.Unlock()
+	if lock.users--; lock.users == 0 {
+		delete(nl.locks, name)
+	}
+}
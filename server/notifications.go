@@ -0,0 +1,201 @@
+package server
+
+////
+//// VSD changes made outside of the agent (e.g. in the VSD GUI) to the objects backing the local state: Containers, VPorts, Subnets and Zones.
+//// Detected through the VSD push notifications
+////
+
+import (
+	"encoding/json"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/metrics"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+var outOfBand = metrics.NewCounterVec("vsd_out_of_band_changes_total", "VSD changes made outside of the agent to objects backing the local state, by entity type and event type", "entity", "type")
+
+// XXX - The VSD also notifies the changes made by the agent itself (or by the OCI hook, for containers). Those are told apart by their outcome only:
+// E.g. a container deleted from the VSD while still cached by the agent
+func init() {
+	vsdclient.RegisterPushHandler(vspk.ContainerIdentity, onContainerEvent)
+	vsdclient.RegisterPushHandler(vspk.VPortIdentity, onVPortEvent)
+	vsdclient.RegisterPushHandler(vspk.SubnetIdentity, onSubnetEvent)
+	vsdclient.RegisterPushHandler(vspk.ZoneIdentity, onZoneEvent)
+}
+
+// Cached container deleted or changed on the VSD: Invalidate -- or refresh -- the cached container
+func onContainerEvent(event *bambou.Event) {
+	container := vspk.Container{}
+	if err := json.Unmarshal(event.Data, &container); err != nil || container.Name == "" {
+		return
+	}
+
+	background(func() {
+		cachemutex.Lock()
+		defer cachemutex.Unlock()
+
+		if _, cached := agent.Containers[container.Name]; !cached {
+			return
+		}
+
+		switch event.Type {
+		case vsdclient.PushDelete:
+			outOfBand.Inc(event.EntityType, event.Type)
+			log.Warningf("Cached Container: %s (ID: %s) deleted on the VSD. Invalidating the cached Container and its Interfaces", container.Name, container.ID)
+
+			before := snapshots[ResourceContainers](container.Name)
+			delete(agent.Containers, container.Name)
+			emitEvent(ResourceContainers, container.Name, before, "")
+
+			before = snapshots[ResourceInterfaces](container.Name)
+			delete(agent.Interfaces, container.Name)
+			emitEvent(ResourceInterfaces, container.Name, before, "")
+
+			scopesmutex.Lock()
+			delete(containerScopes, container.Name)
+			scopesmutex.Unlock()
+
+			placementsmutex.Lock()
+			delete(Placements, container.Name)
+			placementsmutex.Unlock()
+
+		case vsdclient.PushUpdate:
+			outOfBand.Inc(event.EntityType, event.Type)
+			log.Warningf("Cached Container: %s (ID: %s) changed on the VSD. Refreshing the cached Container", container.Name, container.ID)
+
+			before := snapshots[ResourceContainers](container.Name)
+			agent.Containers[container.Name] = container
+			emitEvent(ResourceContainers, container.Name, before, snapshots[ResourceContainers](container.Name))
+		}
+	})
+}
+
+// VPort of a container backing a local object (Mirror, VIP, Redirection Target) deleted on the VSD
+func onVPortEvent(event *bambou.Event) {
+	vport := vspk.VPort{}
+	if err := json.Unmarshal(event.Data, &vport); err != nil || vport.ID == "" || event.Type != vsdclient.PushDelete {
+		return
+	}
+
+	background(func() {
+		affected := false
+
+		mirrorsmutex.Lock()
+		for _, mirror := range Mirrors {
+			if mirror.VPortID == vport.ID {
+				affected = true
+				log.Warningf("VPort: %s (ID: %s) of mirrored Container: %s deleted on the VSD. The Mirror is left to expire", vport.Name, vport.ID, mirror.Container)
+			}
+		}
+		mirrorsmutex.Unlock()
+
+		// The VSD Virtual IP is gone with the VPort
+		vipsmutex.Lock()
+		for _, vip := range VIPs {
+			if vip.VPortID == vport.ID {
+				affected = true
+				log.Warningf("VPort: %s (ID: %s) holding VIP: %s (%s) deleted on the VSD. VIP no longer held by Container: %s", vport.Name, vport.ID, vip.Name, vip.VirtualIP, vip.Active)
+				vip.Active, vip.VPortID, vip.ID = "", "", ""
+			}
+		}
+		vipsmutex.Unlock()
+
+		// Converged again at the next convergence round
+		redirectionmutex.Lock()
+		for _, rt := range RedirectionTargets {
+			if rt.VPortID == vport.ID {
+				affected = true
+				log.Warningf("VPort: %s (ID: %s) backing Redirection Target: %s deleted on the VSD", vport.Name, vport.ID, rt.Name)
+				rt.VPortID = ""
+			}
+		}
+		redirectionmutex.Unlock()
+
+		if affected {
+			outOfBand.Inc(event.EntityType, event.Type)
+		}
+	})
+}
+
+// Subnet of IP Reservations or VIPs deleted or changed on the VSD
+// XXX - Local objects recorded without a Subnet ID (e.g. by earlier agent versions) refer to Subnets by name, so renamed Subnets go unnoticed
+func onSubnetEvent(event *bambou.Event) {
+	subnet := vspk.Subnet{}
+	if err := json.Unmarshal(event.Data, &subnet); err != nil || subnet.Name == "" {
+		return
+	}
+	if event.Type != vsdclient.PushDelete && event.Type != vsdclient.PushUpdate {
+		return
+	}
+
+	background(func() {
+		affected := false
+
+		reservationsmutex.Lock()
+		for name, reservation := range Reservations {
+			if !sameSubnet(reservation.SubnetID, reservation.Subnet, &subnet) {
+				continue
+			}
+			affected = true
+			if event.Type == vsdclient.PushDelete {
+				// The VSD IP Reservation is gone with the Subnet
+				log.Warningf("Subnet: %s (ID: %s) deleted on the VSD. Dropping IP Reservation: %s for Container: %s", subnet.Name, subnet.ID, reservation.IPAddress, name)
+				delete(Reservations, name)
+			} else {
+				log.Warningf("Subnet: %s (ID: %s) of IP Reservation: %s for Container: %s changed on the VSD. Now: %s/%s", subnet.Name, subnet.ID, reservation.IPAddress, name, subnet.Address, subnet.Netmask)
+			}
+		}
+		reservationsmutex.Unlock()
+
+		vipsmutex.Lock()
+		for _, vip := range VIPs {
+			if sameSubnet(vip.SubnetID, vip.Subnet, &subnet) {
+				affected = true
+				log.Warningf("Subnet: %s (ID: %s) of VIP: %s (%s) changed on the VSD: %s", subnet.Name, subnet.ID, vip.Name, vip.VirtualIP, event.Type)
+			}
+		}
+		vipsmutex.Unlock()
+
+		if affected {
+			outOfBand.Inc(event.EntityType, event.Type)
+		}
+	})
+}
+
+// Zone of cached containers deleted or changed on the VSD
+func onZoneEvent(event *bambou.Event) {
+	zone := vspk.Zone{}
+	if err := json.Unmarshal(event.Data, &zone); err != nil || zone.Name == "" || vsdclient.GetTenantByDomainID(zone.ParentID) == nil {
+		return
+	}
+	if event.Type != vsdclient.PushDelete && event.Type != vsdclient.PushUpdate {
+		return
+	}
+
+	background(func() {
+		var containers []string
+		scopesmutex.Lock()
+		for name, scope := range containerScopes {
+			if scope.zone == zone.Name {
+				containers = append(containers, name)
+			}
+		}
+		scopesmutex.Unlock()
+
+		if len(containers) > 0 {
+			outOfBand.Inc(event.EntityType, event.Type)
+			log.Warningf("Zone: %s (ID: %s) of cached Containers: %v changed on the VSD: %s", zone.Name, zone.ID, containers, event.Type)
+		}
+	})
+}
+
+// Whether a local object refers to a given VSD Subnet: By ID if known, otherwise by name
+func sameSubnet(id, name string, subnet *vspk.Subnet) bool {
+	if id != "" {
+		return id == subnet.ID
+	}
+	return name == subnet.Name
+}
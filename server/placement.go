@@ -0,0 +1,83 @@
+package server
+
+////
+//// Container placement kept by the agent past Container caching: Policy Groups assigned to the container VPort once its interfaces are known
+////
+
+import (
+	"context"
+	"sync"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/placement"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+var (
+	// Placement of the cached containers
+	// Key: Container Name
+	Placements = make(map[string]*placement.Placement)
+
+	placementsmutex sync.Mutex
+)
+
+func init() {
+	containerSetups = append(containerSetups, assignPolicyGroups)
+	containerCleanups = append(containerCleanups, func(ctx context.Context, name string) {
+		placementsmutex.Lock()
+		delete(Placements, name)
+		placementsmutex.Unlock()
+	})
+}
+
+// Record the placement of a cached container, replacing any previous one
+func recordPlacement(name string, p *placement.Placement) {
+	placementsmutex.Lock()
+	Placements[name] = p
+	placementsmutex.Unlock()
+}
+
+////////
+//////// Util
+////////
+
+// Set the static IP address of a container about to be created, re-using the MAC address of its IP Reservation if it is for the same IP address
+func applyStaticIP(container *vspk.Container, p *placement.Placement) {
+	reservationsmutex.Lock()
+	reservation, exists := Reservations[container.Name]
+	reservationsmutex.Unlock()
+
+	switch {
+	case !exists:
+		(*vsdclient.Container)(container).SetIP(p.IP)
+	case reservedIn(reservation, p) && reservation.IPAddress == p.IP:
+		(*vsdclient.Container)(container).SetIPandMAC(reservation.IPAddress, reservation.MAC)
+	default:
+		log.Warningf("IP Reservation for Container: %s is for IP address: %s in Subnet: %s. Using static IP address: %s instead", container.Name, reservation.IPAddress, reservation.Subnet, p.IP)
+		(*vsdclient.Container)(container).SetIP(p.IP)
+	}
+	log.Infof("Using static IP address: %s for Container: %s", p.IP, container.Name)
+}
+
+// Assign the VPort of a container to the Policy Groups of its placement, if any
+func assignPolicyGroups(ctx context.Context, name string) {
+	placementsmutex.Lock()
+	p, exists := Placements[name]
+	placementsmutex.Unlock()
+
+	if !exists || len(p.PolicyGroups) == 0 {
+		return
+	}
+
+	container := &vsdclient.Container{Name: name}
+	if err := container.FetchByName(ctx); err != nil || container.ID == "" {
+		log.Warningf("Cannot assign Policy Groups to Container: %s. Container not found on the VSD", name)
+		return
+	}
+
+	if err := container.AssignPolicyGroups(ctx, p.PolicyGroups); err != nil {
+		log.Errorf("Cannot assign Policy Groups to Container: %s. Error: %s", name, err)
+		return
+	}
+	auditVSD(ctx, "Assigned VPort of Container: %s to Policy Groups: %v", name, p.PolicyGroups)
+}
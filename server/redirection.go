@@ -0,0 +1,255 @@
+package server
+
+////
+//// Service chaining: Redirection targets backed by containers, plus forwarding rules steering traffic to them
+////
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+const (
+	RedirectionTargetPath = "/nuage/redirectiontargets/" // Agent server relative path for Redirection Targets
+
+	convergeInterval = 30 * time.Second // How often the VSD state of Redirection Targets is converged towards their declaration
+)
+
+// Declared Redirection Target, plus the outcome of its last convergence
+type redirectionTarget struct {
+	vsdclient.RedirectionTarget
+	Status string `json:"status"`
+}
+
+// Redirection Target status
+const (
+	RedirectionTargetConverged = "CONVERGED"
+	RedirectionTargetNoBacking = "BACKING CONTAINER NOT FOUND"
+)
+
+var (
+	// Declared Redirection Targets
+	// Key: Redirection Target Name
+	RedirectionTargets = make(map[string]*redirectionTarget)
+
+	// XXX - Held for the whole duration of Redirection Target operations, including the VSD calls.
+	redirectionmutex sync.Mutex
+)
+
+func init() {
+	// Tear down the VSD Redirection Targets backed by containers that are gone
+	containerCleanups = append(containerCleanups, func(ctx context.Context, name string) {
+		redirectionmutex.Lock()
+		defer redirectionmutex.Unlock()
+
+		for _, rt := range RedirectionTargets {
+			if rt.Container == name {
+				teardownRedirectionTarget(ctx, rt)
+			}
+		}
+	})
+}
+
+// Periodically converge the VSD state of all the declared Redirection Targets, until shutdown
+func convergeRedirectionTargets() {
+	ticker := time.NewTicker(convergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopping:
+			return
+		case <-ticker.C:
+		}
+
+		background(func() {
+			redirectionmutex.Lock()
+			defer redirectionmutex.Unlock()
+			for _, rt := range RedirectionTargets {
+				convergeRedirectionTarget(context.Background(), rt)
+			}
+		})
+	}
+}
+
+// Declare a Redirection Target. Any existing Redirection Target with the same name is replaced
+func putRedirectionTarget(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	rt := redirectionTarget{}
+	if err := json.NewDecoder(req.Body).Decode(&rt.RedirectionTarget); err != nil {
+		log.Errorf("Redirection Target create request - JSON decoding error: %s", err)
+		agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetCannotCreate+vars["name"], "JSON decoding error"), http.StatusBadRequest)
+		return
+	}
+	rt.Name = vars["name"]
+	rt.ID, rt.VPortID = "", ""
+
+	if rt.Container == "" {
+		log.Errorf("Redirection Target create request error: No backing container for Redirection Target: %s", rt.Name)
+		agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetCannotCreate+rt.Name, "Backing container is missing"), http.StatusBadRequest)
+		return
+	}
+
+	// The tenant defaults to the one the backing container is placed in, if cached
+	placementsmutex.Lock()
+	if p, exists := Placements[rt.Container]; exists && rt.Enterprise == "" && rt.Domain == "" {
+		rt.Enterprise, rt.Domain = p.Enterprise, p.Domain
+	}
+	placementsmutex.Unlock()
+
+	tenant := rt.Tenant()
+	if tenant == nil {
+		log.Errorf("Redirection Target create request error: Enterprise: %s and Domain: %s of Redirection Target: %s do not match local configuration", rt.Enterprise, rt.Domain, rt.Name)
+		agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetCannotCreate+rt.Name, "A valid Enterprise and Domain are required"), http.StatusBadRequest)
+		return
+	}
+
+	for _, rule := range rt.Rules {
+		rule.ID = ""
+		for _, match := range []*vsdclient.Match{&rule.Source, &rule.Destination} {
+			if match.Type == "" {
+				match.Type = vsdclient.MatchAny
+			}
+			if err := match.Validate(req.Context(), tenant); err != nil {
+				log.Errorf("Redirection Target create request error: %s", err)
+				agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetCannotCreate+rt.Name, err.Error()), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	redirectionmutex.Lock()
+	defer redirectionmutex.Unlock()
+
+	if oldrt, exists := RedirectionTargets[rt.Name]; exists {
+		if err := deleteRT(req.Context(), oldrt); err != nil {
+			agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetCannotCreate+rt.Name, err.Error()), http.StatusConflict)
+			return
+		}
+		delete(RedirectionTargets, rt.Name)
+	}
+
+	RedirectionTargets[rt.Name] = &rt
+	convergeRedirectionTarget(req.Context(), &rt)
+
+	log.Infof("Successfully declared Redirection Target: %s backed by Container: %s. Status: %s", rt.Name, rt.Container, rt.Status)
+	agent.Sendjson(w, rt, http.StatusCreated)
+}
+
+// List all Redirection Targets
+func getRedirectionTargets(w http.ResponseWriter, req *http.Request) {
+	log.Info("Serving list of Redirection Targets")
+	redirectionmutex.Lock()
+	defer redirectionmutex.Unlock()
+
+	var resp []redirectionTarget
+	for _, rt := range RedirectionTargets {
+		resp = append(resp, *rt)
+	}
+	agent.Sendjson(w, resp, http.StatusOK)
+}
+
+// Get Redirection Target with given Name
+func getRedirectionTarget(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	redirectionmutex.Lock()
+	defer redirectionmutex.Unlock()
+
+	if rt, exists := RedirectionTargets[vars["name"]]; exists {
+		log.Infof("Serving Redirection Target: %s", rt.Name)
+		agent.Sendjson(w, rt, http.StatusOK)
+	} else {
+		log.Warningf("Cannot find Redirection Target: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetNotFound+vars["name"], ""), http.StatusNotFound)
+	}
+}
+
+// Delete a Redirection Target and its forwarding rules
+func deleteRedirectionTarget(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	redirectionmutex.Lock()
+	defer redirectionmutex.Unlock()
+
+	rt, exists := RedirectionTargets[vars["name"]]
+	if !exists {
+		log.Warningf("Cannot find Redirection Target: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetNotFound+vars["name"], ""), http.StatusNotFound)
+		return
+	}
+
+	if err := deleteRT(req.Context(), rt); err != nil {
+		agent.Sendjson(w, bambou.NewBambouError(RedirectionTargetCannotDelete+rt.Name, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	delete(RedirectionTargets, rt.Name)
+	log.Infof("Successfully deleted Redirection Target: %s", rt.Name)
+	agent.Sendjson(w, nil, http.StatusOK)
+}
+
+////////
+//////// Util
+////////
+
+// Converge a Redirection Target if its backing container is running, otherwise tear it down.
+// XXX - Needs the redirectionmutex held by the caller
+func convergeRedirectionTarget(ctx context.Context, rt *redirectionTarget) {
+	container := &vsdclient.Container{Name: rt.Container}
+	if err := container.FetchByName(ctx); err != nil {
+		rt.Status = err.Error()
+		return
+	}
+
+	if container.ID == "" {
+		teardownRedirectionTarget(ctx, rt)
+		return
+	}
+
+	err := rt.Converge(ctx, container)
+	auditVSD(ctx, "Converged Redirection Target: %s (ID: %s) on VPort: %s of Container: %s", rt.Name, rt.ID, rt.VPortID, rt.Container)
+	if err != nil {
+		log.Errorf("Failed to converge Redirection Target: %s. Error: %s", rt.Name, err)
+		rt.Status = err.Error()
+		return
+	}
+
+	rt.Status = RedirectionTargetConverged
+}
+
+// Remove the VSD state of a Redirection Target whose backing container is gone. The declaration is kept, so it converges again once the container is back.
+// XXX - Needs the redirectionmutex held by the caller
+func teardownRedirectionTarget(ctx context.Context, rt *redirectionTarget) {
+	if rt.Status == RedirectionTargetNoBacking {
+		return
+	}
+
+	if err := deleteRT(ctx, rt); err != nil {
+		log.Errorf("Failed to tear down Redirection Target: %s. Error: %s", rt.Name, err)
+		rt.Status = err.Error()
+		return
+	}
+
+	log.Warningf("Backing Container: %s of Redirection Target: %s is gone", rt.Container, rt.Name)
+	rt.Status = RedirectionTargetNoBacking
+}
+
+// Delete a Redirection Target and its forwarding rules from the VSD, recording the resulting VSD operation
+func deleteRT(ctx context.Context, rt *redirectionTarget) error {
+	id := rt.ID
+	if err := rt.Delete(ctx); err != nil {
+		return err
+	}
+	if id != "" {
+		auditVSD(ctx, "Deleted Redirection Target: %s (ID: %s) and its forwarding rules", rt.Name, id)
+	}
+	return nil
+}
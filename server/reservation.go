@@ -0,0 +1,321 @@
+package server
+
+////
+//// Sticky IP addresses for named containers, backed by VSD IP Reservations
+////
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/placement"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+const (
+	ReservationPath = "/nuage/reservations/" // Agent server relative path for container IP reservations
+)
+
+var (
+	// Name-to-IP bindings
+	// Key: Container Name
+	Reservations = make(map[string]*vsdclient.Reservation)
+
+	reservationsmutex sync.Mutex
+)
+
+func init() {
+	// Record the IP address of a container once its interfaces are known
+	containerSetups = append(containerSetups, recordReservation)
+}
+
+// Load the existing IP Reservations from the VSD, so they are re-used across agent restarts
+func loadReservations() error {
+	reservations, err := vsdclient.FetchReservations(context.Background())
+	if err != nil {
+		return err
+	}
+
+	reservationsmutex.Lock()
+	defer reservationsmutex.Unlock()
+
+	for _, reservation := range reservations {
+		Reservations[reservation.Name] = reservation
+	}
+
+	log.Infof("Loaded %d IP Reservations from the VSD", len(reservations))
+	return nil
+}
+
+// Pin the IP address of a given container name. Any existing reservation for that container is replaced
+func putReservation(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	reservation := vsdclient.Reservation{}
+	if err := json.NewDecoder(req.Body).Decode(&reservation); err != nil {
+		log.Errorf("IP Reservation create request - JSON decoding error: %s", err)
+		agent.Sendjson(w, bambou.NewBambouError(ReservationCannotCreate+vars["name"], "JSON decoding error"), http.StatusBadRequest)
+		return
+	}
+	reservation.Name = vars["name"]
+	reservation.ID = ""
+	reservation.SubnetID = ""
+
+	// The tenant defaults to the one the container is placed in, if cached
+	placementsmutex.Lock()
+	if p, exists := Placements[reservation.Name]; exists && reservation.Enterprise == "" && reservation.Domain == "" {
+		reservation.Enterprise, reservation.Domain = p.Enterprise, p.Domain
+	}
+	placementsmutex.Unlock()
+
+	if reservation.Enterprise == "" || reservation.Domain == "" || reservation.Subnet == "" || net.ParseIP(reservation.IPAddress) == nil {
+		log.Errorf("IP Reservation create request error: Invalid Enterprise: %s, Domain: %s, Subnet: %s or IP address: %s", reservation.Enterprise, reservation.Domain, reservation.Subnet, reservation.IPAddress)
+		agent.Sendjson(w, bambou.NewBambouError(ReservationCannotCreate+reservation.Name, "A valid Enterprise, Domain, Subnet and IP address are required"), http.StatusBadRequest)
+		return
+	}
+
+	if reservation.MAC == "" {
+		reservation.MAC = vsdclient.GenerateMAC()
+	}
+
+	reservationsmutex.Lock()
+	previous, replaced := Reservations[reservation.Name]
+	reservationsmutex.Unlock()
+
+	if replaced && sameAddress(previous, &reservation) {
+		// The VSD cannot hold both reservations: The previous one is released first, and restored if the new one cannot be created
+		if err := releaseReservation(req.Context(), reservation.Name); err != nil {
+			agent.Sendjson(w, bambou.NewBambouError(ReservationCannotCreate+reservation.Name, err.Error()), http.StatusConflict)
+			return
+		}
+
+		if err := reservation.Create(req.Context()); err != nil {
+			log.Errorf("IP Reservation create request error: %s", err)
+			if rerr := restoreReservation(req.Context(), previous); rerr != nil {
+				agent.Sendjson(w, bambou.NewBambouError(ReservationCannotCreate+reservation.Name, err.Error()+". Previous IP Reservation released and not restored: "+rerr.Error()), http.StatusInternalServerError)
+				return
+			}
+			agent.Sendjson(w, err, http.StatusConflict)
+			return
+		}
+		auditVSD(req.Context(), "Created IP Reservation: %s for IP address: %s in Subnet: %s", reservation.ID, reservation.IPAddress, reservation.Subnet)
+	} else {
+		// The new reservation is created first, so the IP address stays reserved throughout
+		if err := reservation.Create(req.Context()); err != nil {
+			log.Errorf("IP Reservation create request error: %s", err)
+			agent.Sendjson(w, err, http.StatusConflict)
+			return
+		}
+		auditVSD(req.Context(), "Created IP Reservation: %s for IP address: %s in Subnet: %s", reservation.ID, reservation.IPAddress, reservation.Subnet)
+
+		// The previous reservation is kept if it cannot be released: The new one is dropped instead
+		if err := releaseReservation(req.Context(), reservation.Name); err != nil {
+			id := reservation.ID
+			if derr := reservation.Delete(req.Context()); derr != nil {
+				log.Errorf("Cannot remove IP Reservation: %s in Subnet: %s for Container: %s. Both IP Reservations are left on the VSD. Error: %s", reservation.IPAddress, reservation.Subnet, reservation.Name, derr)
+			} else {
+				auditVSD(req.Context(), "Deleted IP Reservation: %s for IP address: %s in Subnet: %s", id, reservation.IPAddress, reservation.Subnet)
+			}
+			agent.Sendjson(w, bambou.NewBambouError(ReservationCannotCreate+reservation.Name, err.Error()), http.StatusConflict)
+			return
+		}
+	}
+
+	reservationsmutex.Lock()
+	Reservations[reservation.Name] = &reservation
+	reservationsmutex.Unlock()
+
+	log.Infof("Successfully pinned IP address: %s for Container: %s", reservation.IPAddress, reservation.Name)
+	agent.Sendjson(w, reservation, http.StatusCreated)
+}
+
+// List all IP reservations
+func getReservations(w http.ResponseWriter, req *http.Request) {
+	log.Info("Serving list of IP Reservations")
+	reservationsmutex.Lock()
+	defer reservationsmutex.Unlock()
+
+	var resp []vsdclient.Reservation
+	for _, reservation := range Reservations {
+		resp = append(resp, *reservation)
+	}
+	agent.Sendjson(w, resp, http.StatusOK)
+}
+
+// Get the IP reservation of a given container
+func getReservation(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	reservationsmutex.Lock()
+	defer reservationsmutex.Unlock()
+
+	if reservation, exists := Reservations[vars["name"]]; exists {
+		log.Infof("Serving IP Reservation for Container: %s", vars["name"])
+		agent.Sendjson(w, reservation, http.StatusOK)
+	} else {
+		log.Warningf("Cannot find IP Reservation for Container: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(ReservationNotFound+vars["name"], ""), http.StatusNotFound)
+	}
+}
+
+// Release the IP reservation of a given container
+func deleteReservation(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	reservationsmutex.Lock()
+	_, exists := Reservations[vars["name"]]
+	reservationsmutex.Unlock()
+
+	if !exists {
+		log.Warningf("Cannot find IP Reservation for Container: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(ReservationNotFound+vars["name"], ""), http.StatusNotFound)
+		return
+	}
+
+	if err := releaseReservation(req.Context(), vars["name"]); err != nil {
+		agent.Sendjson(w, bambou.NewBambouError(ReservationCannotDelete+vars["name"], err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	agent.Sendjson(w, nil, http.StatusOK)
+}
+
+////////
+//////// Util
+////////
+
+// Re-use the reserved IP and MAC addresses (if any) in the metadata of a container about to be created with the given placement
+func applyReservation(container *vspk.Container, p *placement.Placement) {
+	reservationsmutex.Lock()
+	reservation, exists := Reservations[container.Name]
+	reservationsmutex.Unlock()
+
+	if !exists {
+		return
+	}
+
+	if !reservedIn(reservation, p) {
+		log.Warningf("IP Reservation for Container: %s is in Enterprise: %s, Domain: %s, Subnet: %s instead of Enterprise: %s, Domain: %s, Subnet: %s. Ignoring it",
+			container.Name, reservation.Enterprise, reservation.Domain, reservation.Subnet, p.Enterprise, p.Domain, p.Subnet)
+		return
+	}
+
+	(*vsdclient.Container)(container).SetIPandMAC(reservation.IPAddress, reservation.MAC)
+	log.Infof("Re-using reserved IP address: %s for Container: %s", reservation.IPAddress, container.Name)
+}
+
+// Record the IP address the VSD allocated to a given container, if it does not have a reservation already
+func recordReservation(ctx context.Context, name string) {
+	reservationsmutex.Lock()
+	_, exists := Reservations[name]
+	reservationsmutex.Unlock()
+
+	if exists {
+		return
+	}
+
+	container := &vsdclient.Container{Name: name}
+	if err := container.FetchByName(ctx); err != nil || container.ID == "" {
+		log.Warningf("Cannot record IP Reservation for Container: %s. Container not found on the VSD", name)
+		return
+	}
+
+	ciface, err := container.Interface(ctx)
+	if err != nil {
+		log.Warningf("Cannot record IP Reservation for Container: %s. Error: %s", name, err)
+		return
+	}
+
+	// XXX - IP Reservations are only available in L3 Domain Subnets
+	if ciface.AttachedNetworkType == "L2DOMAIN" {
+		return
+	}
+
+	tenant := vsdclient.GetTenantByDomainID(ciface.DomainID)
+	if tenant == nil {
+		log.Warningf("Cannot record IP Reservation for Container: %s. Container is not part of a local tenant Domain", name)
+		return
+	}
+
+	reservation := &vsdclient.Reservation{
+		Name:       name,
+		Enterprise: tenant.Enterprise.Name,
+		Domain:     tenant.Domain.Name,
+		Subnet:     ciface.NetworkName,
+		IPAddress:  ciface.IPAddress,
+		MAC:        ciface.MAC,
+	}
+
+	if err := reservation.Create(ctx); err != nil {
+		log.Warningf("Cannot record IP Reservation for Container: %s. Error: %s", name, err)
+		return
+	}
+	auditVSD(ctx, "Created IP Reservation: %s for IP address: %s in Subnet: %s", reservation.ID, reservation.IPAddress, reservation.Subnet)
+
+	reservationsmutex.Lock()
+	Reservations[name] = reservation
+	reservationsmutex.Unlock()
+
+	log.Infof("Recorded IP address: %s for Container: %s", reservation.IPAddress, name)
+}
+
+// Re-create a released reservation, e.g. when replacing it failed
+func restoreReservation(ctx context.Context, reservation *vsdclient.Reservation) error {
+	reservation.ID = ""
+	if err := reservation.Create(ctx); err != nil {
+		log.Errorf("!!! Cannot restore IP Reservation: %s in Subnet: %s for Container: %s. The IP address is no longer reserved !!! Error: %s", reservation.IPAddress, reservation.Subnet, reservation.Name, err)
+		return err
+	}
+	auditVSD(ctx, "Created IP Reservation: %s for IP address: %s in Subnet: %s", reservation.ID, reservation.IPAddress, reservation.Subnet)
+
+	reservationsmutex.Lock()
+	if _, exists := Reservations[reservation.Name]; !exists {
+		Reservations[reservation.Name] = reservation
+	}
+	reservationsmutex.Unlock()
+
+	log.Warningf("Restored IP Reservation: %s in Subnet: %s for Container: %s", reservation.IPAddress, reservation.Subnet, reservation.Name)
+	return nil
+}
+
+// Remove the reservation of a given container (if any) from both the VSD and the local cache.
+func releaseReservation(ctx context.Context, name string) error {
+	reservationsmutex.Lock()
+	reservation, exists := Reservations[name]
+	reservationsmutex.Unlock()
+
+	if !exists {
+		return nil
+	}
+
+	id := reservation.ID
+	if err := reservation.Delete(ctx); err != nil {
+		log.Errorf("Failed to release IP Reservation for Container: %s. Error: %s", name, err)
+		return err
+	}
+	auditVSD(ctx, "Deleted IP Reservation: %s for IP address: %s in Subnet: %s", id, reservation.IPAddress, reservation.Subnet)
+
+	reservationsmutex.Lock()
+	delete(Reservations, name)
+	reservationsmutex.Unlock()
+
+	log.Infof("Successfully released IP Reservation for Container: %s", name)
+	return nil
+}
+
+// Whether two reservations hold the same IP or MAC address in the same Subnet, which the VSD does not allow
+func sameAddress(r1, r2 *vsdclient.Reservation) bool {
+	return r1.Enterprise == r2.Enterprise && r1.Domain == r2.Domain && r1.Subnet == r2.Subnet && (r1.IPAddress == r2.IPAddress || strings.EqualFold(r1.MAC, r2.MAC))
+}
+
+// Whether a reservation is in the Enterprise, Domain and Subnet of a container placement
+func reservedIn(reservation *vsdclient.Reservation, p *placement.Placement) bool {
+	return reservation.Enterprise == p.Enterprise && reservation.Domain == p.Domain && reservation.Subnet == p.Subnet
+}
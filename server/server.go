@@ -5,105 +5,314 @@ package server
 ////
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"sync"
 
 	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
-	"github.com/OpenPlatformSDN/nuage-cni/config"
-	"github.com/OpenPlatformSDN/nuage-cni/errors"
+	"github.com/OpenPlatformSDN/nuage-cni/agent/types"
+	nuagecni "github.com/OpenPlatformSDN/nuage-cni/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/audit"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/metrics"
 	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
-	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 	"github.com/nuagenetworks/go-bambou/bambou"
-	"github.com/nuagenetworks/vspk-go/vspk"
 )
 
+// Default agent server handlers we wrap with local processing
+var (
+	defaultPutContainerInterfaces    = agent.PutContainerInterfaces
+	defaultDeleteContainerInterfaces = agent.DeleteContainerInterfaces
+)
+
+// Processing of local state associated with a container (e.g. IP reservations, port mirroring).
+// - Setups are run when the container interfaces are created, i.e. the container is up and running.
+// - Cleanups are run when the container interfaces are deleted, i.e. the container is gone.
+var (
+	containerSetups   []func(ctx context.Context, name string)
+	containerCleanups []func(ctx context.Context, name string)
+)
+
+// Agent server certificate. Swapped at configuration reload without restarting the listener
+var (
+	certificate *tls.Certificate
+	certmutex   sync.RWMutex
+)
+
+// Guard of the agent caches: "agent.Networks", "agent.Containers" and "agent.Interfaces". Held by the agent handlers of those caches -- see "cached" -- and by any other accessor.
+// XXX - Held across the VSD calls made by those handlers (e.g. container validation), which are thus serialized
+var cachemutex sync.Mutex
+
 // Wrapper function around the agent Server
+// XXX - "agent.Server" does not allow adding routes, so we replicate its routes here on top of which we add the local ones
 
-func Server(conf config.AgentConfig) error {
+func Server(conf *config.Config) error {
 
-	// Use the locally defined handler for Container PUT instead of the agent server default
+	// Use the locally defined handlers for Container PUT and Container Interfaces PUT / DELETE instead of the agent server defaults
 	agent.PutContainer = putContainer
+	agent.PutContainerInterfaces = putContainerInterfaces
+	agent.DeleteContainerInterfaces = deleteContainerInterfaces
 
-	return agent.Server(conf)
+	if err := Reload(conf); err != nil {
+		return err
+	}
 
-}
+	if conf.Listen.DisableTCP && conf.Listen.UnixSocket == "" {
+		return bambou.NewBambouError("No agent server listener", "TCP listener disabled without a Unix domain socket listener")
+	}
 
-// Local handler for ContainerPUT.
+	if conf.Audit.File != "" {
+		if l, err := audit.Open(conf.Audit.File, conf.Audit.MaxSize, conf.Audit.MaxFiles); err != nil {
+			log.Errorf("Cannot open audit log: %s. Error: %s", conf.Audit.File, err)
+			return err
+		} else {
+			auditlog, auditFailOpen = l, conf.Audit.FailOpen
+			log.Infof("Auditing agent API changes to: %s", conf.Audit.File)
+		}
+	}
 
-func putContainer(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
+	if err := loadReservations(); err != nil {
+		return err
+	}
 
-	newc := vspk.Container{}
-	if err := json.NewDecoder(req.Body).Decode(&newc); err != nil {
-		glog.Errorf("Container create request - JSON decoding error: %s", err)
-		agent.Sendjson(w, bambou.NewBambouError(errors.ContainerCannotCreate+vars["name"], "JSON decoding error"), http.StatusBadRequest)
-		return
+	if conf.Lifecycle.StateFile != "" {
+		if err := loadState(conf.Lifecycle.StateFile); err != nil {
+			log.Errorf("Cannot restore local state from: %s. Error: %s", conf.Lifecycle.StateFile, err)
+			return err
+		}
 	}
+	setStateLoaded(true)
 
-	// Validate Enterprise name in container metadata against local config
-	if newc.EnterpriseName != vsdclient.Enterprise.Name {
-		err := fmt.Errorf("Container create request error: Container metadata Enterprise Name: %s does not match local configuration", newc.EnterpriseName)
-		glog.Error(err)
-		return
+	// Out-of-band VSD changes to the objects backing the local state, from the last notification before the agent restart (if known)
+	vsdclient.StartNotifications()
+
+	router := mux.NewRouter()
+
+	////
+	//// CNI Networks: Create/Retrieve/Delete CNI NetConf
+	////
+	handleRoute(router, types.NetconfPath, cached(authorize(ResourceNetworks, watched(ResourceNetworks, agent.PostNetwork)))).Methods("POST")
+	handleRoute(router, types.NetconfPath, cached(authorize(ResourceNetworks, versioned(agent.GetNetworks)))).Methods("GET")
+	handleRoute(router, types.NetconfPath+"{name}", cached(authorize(ResourceNetworks, agent.GetNetwork))).Methods("GET")
+	handleRoute(router, types.NetconfPath+"{name}", cached(authorize(ResourceNetworks, watched(ResourceNetworks, agent.DeleteNetwork)))).Methods("DELETE")
+
+	////
+	//// Cached Containers: Cache / retrieve vspk.Container. Only PUT, GET, DELETE.
+	////
+	handleRoute(router, types.ContainerPath+"{name}", cached(authorize(ResourceContainers, watched(ResourceContainers, agent.PutContainer)))).Methods("PUT")
+	handleRoute(router, types.ContainerPath, cached(authorize(ResourceContainers, versioned(agent.GetContainers)))).Methods("GET")
+	handleRoute(router, types.ContainerPath+"{name}", cached(authorize(ResourceContainers, agent.GetContainer))).Methods("GET")
+	handleRoute(router, types.ContainerPath+"{name}", cached(authorize(ResourceContainers, watched(ResourceContainers, agent.DeleteContainer)))).Methods("DELETE")
+
+	////
+	////  CNI Interfaces: Create/Modify/Retreive/Delete []Result
+	////
+	handleRoute(router, types.ResultPath+"{name}", cached(authorize(ResourceInterfaces, watched(ResourceInterfaces, agent.PutContainerInterfaces)))).Methods("PUT")
+	handleRoute(router, types.ResultPath, cached(authorize(ResourceInterfaces, versioned(agent.GetInterfaces)))).Methods("GET")
+	handleRoute(router, types.ResultPath+"{name}", cached(authorize(ResourceInterfaces, agent.GetContainerInterfaces))).Methods("GET")
+	handleRoute(router, types.ResultPath+"{name}", cached(authorize(ResourceInterfaces, watched(ResourceInterfaces, agent.DeleteContainerInterfaces)))).Methods("DELETE")
+
+	////
+	//// Event stream of the changes to the Networks, Containers and Container Interfaces above
+	////
+	handleRoute(router, EventsPath, authorize(ResourceEvents, getEvents)).Methods("GET")
+
+	////
+	//// Port mirroring of container traffic
+	////
+	handleRoute(router, MirrorPath+"{name}", authorize(ResourceMirrors, putMirror)).Methods("PUT")
+	handleRoute(router, MirrorPath, authorize(ResourceMirrors, getMirrors)).Methods("GET")
+	handleRoute(router, MirrorPath+"{name}", authorize(ResourceMirrors, getMirror)).Methods("GET")
+	handleRoute(router, MirrorPath+"{name}", authorize(ResourceMirrors, deleteMirror)).Methods("DELETE")
+
+	////
+	//// Sticky IP addresses of named containers: Pin / list / release
+	////
+	handleRoute(router, ReservationPath+"{name}", authorize(ResourceReservations, putReservation)).Methods("PUT")
+	handleRoute(router, ReservationPath, authorize(ResourceReservations, getReservations)).Methods("GET")
+	handleRoute(router, ReservationPath+"{name}", authorize(ResourceReservations, getReservation)).Methods("GET")
+	handleRoute(router, ReservationPath+"{name}", authorize(ResourceReservations, deleteReservation)).Methods("DELETE")
+
+	////
+	//// Virtual IPs shared by groups of containers: Declare / list / move / delete
+	////
+	handleRoute(router, VIPPath+"{name}", authorize(ResourceVIPs, putVIP)).Methods("PUT")
+	handleRoute(router, VIPPath, authorize(ResourceVIPs, getVIPs)).Methods("GET")
+	handleRoute(router, VIPPath+"{name}", authorize(ResourceVIPs, getVIP)).Methods("GET")
+	handleRoute(router, VIPPath+"{name}/active/{member}", authorize(ResourceVIPs, putVIPActive)).Methods("PUT")
+	handleRoute(router, VIPPath+"{name}", authorize(ResourceVIPs, deleteVIP)).Methods("DELETE")
+
+	////
+	//// Service chaining: Redirection Targets backed by containers, plus their forwarding rules
+	////
+	handleRoute(router, RedirectionTargetPath+"{name}", authorize(ResourceRedirectionTargets, putRedirectionTarget)).Methods("PUT")
+	handleRoute(router, RedirectionTargetPath, authorize(ResourceRedirectionTargets, getRedirectionTargets)).Methods("GET")
+	handleRoute(router, RedirectionTargetPath+"{name}", authorize(ResourceRedirectionTargets, getRedirectionTarget)).Methods("GET")
+	handleRoute(router, RedirectionTargetPath+"{name}", authorize(ResourceRedirectionTargets, deleteRedirectionTarget)).Methods("DELETE")
+
+	////
+	//// Prometheus metrics
+	////
+	handleRoute(router, MetricsPath, authorize(ResourceMetrics, metrics.Handler)).Methods("GET")
+
+	////
+	//// Log levels
+	////
+	handleRoute(router, LogLevelPath, authorize(ResourceLogging, getLogLevels)).Methods("GET")
+	handleRoute(router, LogLevelPath+"{name}", authorize(ResourceLogging, putLogLevel)).Methods("PUT")
+
+	////
+	//// Health and readiness. Also served without client authentication on the health listener, see "serveHealth"
+	////
+	handleRoute(router, HealthPath, authorize(ResourceHealth, getHealth)).Methods("GET")
+	handleRoute(router, ReadinessPath, authorize(ResourceHealth, getReadiness)).Methods("GET")
+
+	////
+	//// Drift of the container interface records from the VSD
+	////
+	handleRoute(router, DriftPath, authorize(ResourceDrift, getDriftReport)).Methods("GET")
+
+	////
+	//// Debugging
+	////
+	handleRoute(router, DebugStatePath, authorize(ResourceDebug, getDebugState)).Methods("GET")
+
+	go convergeRedirectionTargets()
+	go auditDrift()
+
+	// Serve until any of the listeners fails
+	errs := make(chan error, 4)
+
+	if conf.Debug.PprofListen != "" {
+		go func() { errs <- servePprof(conf.Debug.PprofListen) }()
 	}
 
-	glog.Infof("Validated Container metadata - Enterprise: %s", newc.EnterpriseName)
+	if conf.Listen.Health != "" {
+		go func() { errs <- serveHealth(conf.Listen.Health) }()
+	}
 
-	// Validate Domain name (encoded in contaier "DomainIDs") vs local config
-	if len(newc.DomainIDs) != 1 {
-		glog.Error("Container create request error: No metadata for container Domain")
-		return
+	if conf.Listen.UnixSocket != "" {
+		go func() { errs <- serveUnix(conf.Listen.UnixSocket, router) }()
 	}
 
-	if newc.DomainIDs[0].(string) != vsdclient.Domain.Name {
-		glog.Errorf("Container create request error: Container metadata Domain Name: %s does not match local configuration", newc.DomainIDs[0].(string))
-		return
+	if !conf.Listen.DisableTCP {
+		cas, err := clientCAs(conf.AgentServer.CaFile)
+		if err != nil {
+			log.Errorf("Cannot load agent server CA certificate: %s. Error: %s", conf.AgentServer.CaFile, err)
+			return err
+		}
+
+		// Mutual TLS: Clients must present a certificate signed by the agent server CA
+		tc := &tls.Config{
+			GetCertificate: getCertificate,
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+			ClientCAs:      cas,
+		}
+		if err := conf.Listen.TLS.Apply(tc); err != nil {
+			log.Errorf("Invalid agent server TLS policy: %s", err)
+			return err
+		}
+
+		srv := &http.Server{
+			Addr:      ":" + conf.AgentServer.ServerPort,
+			Handler:   authenticate(router),
+			TLSConfig: tc,
+		}
+		addServer(srv)
+
+		l, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			log.Errorf("Cannot listen on agent server port: %s. Error: %s", conf.AgentServer.ServerPort, err)
+			return err
+		}
+
+		go func() {
+			setListening("tcp:"+srv.Addr, true)
+			defer setListening("tcp:"+srv.Addr, false)
+			errs <- srv.ServeTLS(l, "", "")
+		}()
 	}
-	glog.Infof("Validated Container metadata - Domain: %s", newc.DomainIDs[0].(string))
-	// reset that field
-	newc.DomainIDs = nil
 
-	// Validate Zone name (encoded in contaier "ZoneIDs")
-	if len(newc.ZoneIDs) != 1 {
-		glog.Error("Container create request error: No metadata for container Zone")
-		return
+	return <-errs
+
+}
+
+// Register the handler of a route, named after its path template -- see "routeTemplate"
+func handleRoute(router *mux.Router, tpl string, handler http.HandlerFunc) *mux.Route {
+	return router.HandleFunc(tpl, handler).Name(tpl)
+}
+
+// Serve a request of the agent caches with "cachemutex" held
+func cached(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		cachemutex.Lock()
+		defer cachemutex.Unlock()
+		handler(w, req)
 	}
+}
+
+// Local handler for Container Interfaces PUT: Run the default handler, then set up any local state associated with that container
+func putContainerInterfaces(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	defaultPutContainerInterfaces(w, req)
 
-	if vsdclient.GetZone(newc.ZoneIDs[0].(string)) == nil {
-		glog.Errorf("Container create request error: Container metadata Zone Name: %s does not match local configuration", newc.ZoneIDs[0].(string))
+	if _, exists := agent.Interfaces[vars["name"]]; !exists {
 		return
 	}
-	glog.Infof("Validated Container metadata - Zone: %s", newc.ZoneIDs[0].(string))
-	// reset that field
-	newc.ZoneIDs = nil
 
-	// Validate Subnet name (encoded in contaier "SubnetIDs")
-	if len(newc.SubnetIDs) != 1 {
-		glog.Error("Container create request error: No metadata for container Subnet")
-		return
+	for _, setup := range containerSetups {
+		setup(req.Context(), vars["name"])
 	}
+}
+
+// Local handler for Container Interfaces DELETE: Run the default handler, then clean up any local state associated with that container
+func deleteContainerInterfaces(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	_, exists := agent.Interfaces[vars["name"]]
+
+	defaultDeleteContainerInterfaces(w, req)
 
-	if vsdclient.GetSubnet(newc.SubnetIDs[0].(string)) == nil {
-		glog.Errorf("Container create request error: Container metadata Subnet Name: %s does not match local configuration", newc.SubnetIDs[0].(string))
+	if !exists {
 		return
 	}
-	glog.Infof("Validated Container metadata - Subnet: %s", newc.SubnetIDs[0].(string))
-	// reset that field
-	newc.SubnetIDs = nil
 
-	//
-	////
-	////  ...Any additional processing at Container caching
-	////
+	for _, cleanup := range containerCleanups {
+		cleanup(req.Context(), vars["name"])
+	}
+}
 
-	agent.Containers[newc.Name] = newc
+// (Re-)load the agent server settings that can be changed at runtime: Allowed clients, server certificate and private key.
+// On failure the current certificate is kept
+func Reload(conf *config.Config) error {
+	setClients(conf.Clients)
+	setDrift(conf)
+	if conf.Listen.DisableTCP {
+		return nil
+	}
+	return reloadCertificate(conf.AgentServer)
+}
 
-	////
-	//// Response ....
-	////
+func reloadCertificate(conf nuagecni.AgentConfig) error {
+	cert, err := tls.LoadX509KeyPair(conf.CertCaFile, conf.KeyFile)
+	if err != nil {
+		log.Errorf("Cannot load agent server certificate: %s and private key: %s. Error: %s", conf.CertCaFile, conf.KeyFile, err)
+		return err
+	}
+
+	certmutex.Lock()
+	certificate = &cert
+	certmutex.Unlock()
+
+	log.Infof("Loaded agent server certificate: %s", conf.CertCaFile)
+	return nil
+}
 
-	glog.Infof("Successfully cached Nuage Container: %s", newc.Name)
-	agent.Sendjson(w, nil, http.StatusCreated)
+func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certmutex.RLock()
+	defer certmutex.RUnlock()
+	return certificate, nil
 }
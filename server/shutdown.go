@@ -0,0 +1,126 @@
+package server
+
+////
+//// Graceful shutdown: Stop accepting requests, drain the in-flight requests and VSD operations, then save the local state
+////
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+)
+
+var (
+	// Agent API servers, on all the listeners
+	servers      []*http.Server
+	serversmutex sync.Mutex
+
+	// Background operations towards the VSD, i.e. not part of an agent API request: Mirror expiry, Redirection Target convergence
+	backgroundops   sync.WaitGroup
+	backgroundcount int // Background operations in progress
+
+	// Set at shutdown. No new background operations are started
+	draining bool
+
+	// Closed at shutdown, stopping the periodic background operations
+	stopping = make(chan struct{})
+)
+
+// Stop the agent server: Stop accepting requests, then wait -- up to the context deadline -- for the in-flight requests and background VSD operations.
+// The local state is then saved (if configured) and the audit log closed. Returns an error if draining did not complete or the state could not be saved
+func Shutdown(ctx context.Context, conf *config.Config) error {
+	healthmutex.Lock()
+	if !draining {
+		close(stopping)
+	}
+	draining = true
+	healthmutex.Unlock()
+
+	var errs []error
+
+	// Event streams would otherwise hold up draining until the deadline
+	closeEvents()
+
+	serversmutex.Lock()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("In-flight requests not drained: %s", err))
+		}
+	}
+	serversmutex.Unlock()
+	log.Info("Agent server listeners closed")
+
+	// No more VSD push notifications from here on. The last notification ID is saved with the local state
+	vsdclient.StopNotifications()
+
+	done := make(chan struct{})
+	go func() {
+		backgroundops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("Background VSD operations not drained: %s", ctx.Err()))
+	}
+
+	// No more mirror expiry from here on. Expired mirrors are torn down at the next startup (if the state is saved)
+	mirrorsmutex.Lock()
+	for _, timer := range mirrortimers {
+		timer.Stop()
+	}
+	mirrorsmutex.Unlock()
+
+	if conf.Lifecycle.StateFile != "" {
+		if err := saveState(conf.Lifecycle.StateFile); err != nil {
+			log.Errorf("Cannot save local state to: %s. Error: %s", conf.Lifecycle.StateFile, err)
+			errs = append(errs, fmt.Errorf("Local state not saved: %s", err))
+		}
+	}
+
+	if auditlog != nil {
+		if err := auditlog.Close(); err != nil {
+			log.Errorf("Cannot close audit log. Error: %s", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+////////
+//////// Util
+////////
+
+// Record an agent API server, to be stopped at shutdown
+func addServer(srv *http.Server) {
+	serversmutex.Lock()
+	servers = append(servers, srv)
+	serversmutex.Unlock()
+}
+
+// Run a background VSD operation -- unless shutting down. Shutdown waits for it to complete
+func background(op func()) {
+	healthmutex.Lock()
+	if draining {
+		healthmutex.Unlock()
+		return
+	}
+	backgroundops.Add(1)
+	backgroundcount++
+	healthmutex.Unlock()
+
+	defer func() {
+		healthmutex.Lock()
+		backgroundcount--
+		healthmutex.Unlock()
+		backgroundops.Done()
+	}()
+	op()
+}
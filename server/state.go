@@ -0,0 +1,180 @@
+package server
+
+////
+//// Local state of the agent, saved at shutdown and restored at startup: Agent caches plus the local objects backed by VSD objects
+////
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	nuagecnitypes "github.com/OpenPlatformSDN/nuage-cni/types"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/placement"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Saved local state
+type agentState struct {
+	Saved              time.Time                         `json:"saved"`
+	Networks           map[string]nuagecnitypes.NetConf  `json:"networks"`
+	Containers         map[string]vspk.Container         `json:"containers"`
+	Interfaces         map[string][]nuagecnitypes.Result `json:"interfaces"`
+	Scopes             map[string]savedScope             `json:"scopes"`
+	Placements         map[string]*placement.Placement   `json:"placements"`
+	Mirrors            map[string]*vsdclient.Mirror      `json:"mirrors"`
+	VIPs               map[string]*vsdclient.VIP         `json:"vips"`
+	RedirectionTargets map[string]*redirectionTarget     `json:"redirectionTargets"`
+	LastEventID        string                            `json:"lastEventID,omitempty"` // Last VSD push notification received
+}
+
+// Enterprise and Zone of a cached container
+type savedScope struct {
+	Enterprise string `json:"enterprise"`
+	Zone       string `json:"zone"`
+}
+
+// Save the local state to a given file. The file is replaced atomically
+func saveState(file string) error {
+	state := agentState{
+		Saved:       time.Now(),
+		LastEventID: vsdclient.LastEventID(),
+		Networks:    make(map[string]nuagecnitypes.NetConf),
+		Containers:  make(map[string]vspk.Container),
+		Interfaces:  make(map[string][]nuagecnitypes.Result),
+		Scopes:      make(map[string]savedScope),
+	}
+
+	cachemutex.Lock()
+	for name, network := range agent.Networks {
+		state.Networks[name] = network
+	}
+	for name, container := range agent.Containers {
+		state.Containers[name] = container
+	}
+	for name, ifaces := range agent.Interfaces {
+		state.Interfaces[name] = ifaces
+	}
+	cachemutex.Unlock()
+
+	scopesmutex.Lock()
+	for name, scope := range containerScopes {
+		state.Scopes[name] = savedScope{Enterprise: scope.enterprise, Zone: scope.zone}
+	}
+	scopesmutex.Unlock()
+
+	placementsmutex.Lock()
+	state.Placements = make(map[string]*placement.Placement)
+	for name, p := range Placements {
+		state.Placements[name] = p
+	}
+	placementsmutex.Unlock()
+
+	mirrorsmutex.Lock()
+	state.Mirrors = make(map[string]*vsdclient.Mirror)
+	for name, mirror := range Mirrors {
+		state.Mirrors[name] = mirror
+	}
+	mirrorsmutex.Unlock()
+
+	vipsmutex.Lock()
+	state.VIPs = make(map[string]*vsdclient.VIP)
+	for name, vip := range VIPs {
+		state.VIPs[name] = vip
+	}
+	vipsmutex.Unlock()
+
+	redirectionmutex.Lock()
+	state.RedirectionTargets = make(map[string]*redirectionTarget)
+	for name, rt := range RedirectionTargets {
+		state.RedirectionTargets[name] = rt
+	}
+	redirectionmutex.Unlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	log.Infof("Saved local state to: %s -- %d Container(s), %d Mirror(s), %d VIP(s), %d Redirection Target(s)",
+		file, len(state.Containers), len(state.Mirrors), len(state.VIPs), len(state.RedirectionTargets))
+	return nil
+}
+
+// Restore the local state from a given file, if any. Expired mirrors are torn down
+func loadState(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		log.Infof("No saved local state in: %s", file)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state agentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	cachemutex.Lock()
+	for name, network := range state.Networks {
+		agent.Networks[name] = network
+	}
+	for name, container := range state.Containers {
+		agent.Containers[name] = container
+	}
+	for name, ifaces := range state.Interfaces {
+		agent.Interfaces[name] = ifaces
+	}
+	cachemutex.Unlock()
+	for name, scope := range state.Scopes {
+		recordScope(name, scope.Enterprise, scope.Zone)
+	}
+	for name, p := range state.Placements {
+		recordPlacement(name, p)
+	}
+
+	mirrorsmutex.Lock()
+	for name, mirror := range state.Mirrors {
+		Mirrors[name] = mirror
+		// Fires right away for mirrors that expired while the agent was down
+		mirrortimers[name] = expireMirror(mirror, time.Until(mirror.Expires))
+	}
+	mirrorsmutex.Unlock()
+
+	vipsmutex.Lock()
+	for name, vip := range state.VIPs {
+		VIPs[name] = vip
+	}
+	vipsmutex.Unlock()
+
+	redirectionmutex.Lock()
+	for name, rt := range state.RedirectionTargets {
+		RedirectionTargets[name] = rt
+	}
+	redirectionmutex.Unlock()
+
+	// VSD changes made while the agent was down are notified once the push notifications resume
+	vsdclient.SetLastEventID(state.LastEventID)
+
+	log.Infof("Restored local state saved at: %s from: %s -- %d Container(s), %d Mirror(s), %d VIP(s), %d Redirection Target(s)",
+		state.Saved, file, len(state.Containers), len(state.Mirrors), len(state.VIPs), len(state.RedirectionTargets))
+	return nil
+}
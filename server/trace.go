@@ -0,0 +1,70 @@
+package server
+
+////
+//// Request IDs and request tracing of the agent API calls
+////
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
+)
+
+// Header carrying the request ID. An incoming request ID is kept, otherwise one is generated. Returned in the response
+const RequestIDHeader = "X-Request-ID"
+
+// Longest incoming request ID kept as is
+const maxRequestID = 128
+
+// Request ID of a given request: The incoming one, if valid, otherwise a new one
+func requestID(req *http.Request) string {
+	id := req.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestID {
+		return trace.NewID()
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return trace.NewID()
+		}
+	}
+	return id
+}
+
+// Set the request ID of a request, in the request context and in the response
+func withRequestID(w http.ResponseWriter, req *http.Request) *http.Request {
+	id := requestID(req)
+	w.Header().Set(RequestIDHeader, id)
+	return req.WithContext(trace.WithRequestID(req.Context(), id))
+}
+
+// Trace a routed agent API call: One span for the whole call, parent of the spans of its steps (e.g. VSD calls). The span is set in the request context
+func traced(req *http.Request, rec *statusRecorder, resource string) func() {
+	ctx, span := trace.Start(req.Context(), req.Method+" "+routeTemplate(req), "resource", resource, "method", req.Method, "path", req.URL.Path)
+	if client := requestClient(req); client != nil {
+		span.SetAttribute("client", client.identity)
+	}
+
+	setContext(req, ctx)
+	return func() {
+		span.SetAttribute("status", strconv.Itoa(rec.status))
+		span.End(nil)
+	}
+}
+
+// Time a step of an agent API call, e.g. JSON decoding. Returns the context of the step and the function ending it
+func step(ctx context.Context, name string) (context.Context, func(err error)) {
+	ctx, span := trace.Start(ctx, name)
+	return ctx, span.End
+}
+
+////////
+//////// Util
+////////
+
+// Replace the context of a routed request in place
+// XXX - The route variables ("mux.Vars") are kept by request, so a routed request replaced by "WithContext" loses them
+func setContext(req *http.Request, ctx context.Context) {
+	*req = *req.WithContext(ctx)
+}
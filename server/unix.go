@@ -0,0 +1,124 @@
+package server
+
+////
+//// Unix domain socket listener for local clients (e.g. OCI hook, CNI plugin), authorized by the peer credentials of the connection
+////
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+// Prefixes of the identities of Unix domain socket clients. Allowed clients with those identities never match client certificates, and vice versa
+const (
+	PeerUIDPrefix = "uid:"
+	PeerGIDPrefix = "gid:"
+)
+
+type peerCredKey struct{}
+
+// Serve the agent API on a Unix domain socket. Any stale socket is removed first -- anything else at that path is left alone
+func serveUnix(path string, handler http.Handler) error {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			err := fmt.Errorf("%s exists and is not a Unix domain socket", path)
+			log.Errorf("Cannot listen on Unix domain socket: %s. Error: %s", path, err)
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			log.Errorf("Cannot remove stale Unix domain socket: %s. Error: %s", path, err)
+			return err
+		}
+	}
+
+	// XXX - Only the owner and group of the agent can connect. Peer credentials are checked on top of that.
+	// The permissions are set before any connection is accepted: Connections made in between wait until then, and their peer credentials are checked as any other
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.Errorf("Cannot listen on Unix domain socket: %s. Error: %s", path, err)
+		return err
+	}
+
+	if err := os.Chmod(path, 0660); err != nil {
+		log.Errorf("Cannot set the permissions of Unix domain socket: %s. Error: %s", path, err)
+		l.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:     authenticatePeer(handler),
+		ConnContext: peerCredContext,
+	}
+	addServer(srv)
+
+	setListening("unix:"+path, true)
+	defer setListening("unix:"+path, false)
+
+	log.Infof("Listening on Unix domain socket: %s", path)
+	return srv.Serve(l)
+}
+
+// Check the peer credentials of a Unix domain socket client against the allow-list -- by "uid:<UID>" or "gid:<GID>" identity.
+// If the allow-list is empty, only root is allowed
+func authenticatePeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cred, ok := req.Context().Value(peerCredKey{}).(*syscall.Ucred)
+		if !ok {
+			log.Warningf("Rejected %s %s on Unix domain socket. Unknown peer credentials", req.Method, req.URL.Path)
+			agent.Sendjson(w, bambou.NewBambouError(ClientNotAllowed+"unix", "Unknown peer credentials"), http.StatusUnauthorized)
+			return
+		}
+
+		identities := []string{fmt.Sprintf(PeerUIDPrefix+"%d", cred.Uid), fmt.Sprintf(PeerGIDPrefix+"%d", cred.Gid)}
+		client, allowed := allowedClient(identities, true, cred.Uid == 0)
+		if !allowed {
+			log.Warningf("Rejected %s %s on Unix domain socket from PID: %d, UID: %d, GID: %d. Not in the allowed clients", req.Method, req.URL.Path, cred.Pid, cred.Uid, cred.Gid)
+			agent.Sendjson(w, bambou.NewBambouError(ClientNotAllowed+identities[0], "Client not in the allowed clients"), http.StatusForbidden)
+			return
+		}
+
+		req.RemoteAddr = fmt.Sprintf("unix (PID: %d)", cred.Pid)
+		serveClient(w, req, client, next)
+	})
+}
+
+////////
+//////// Util
+////////
+
+// Whether an allowed client identity is a Unix domain socket peer identity
+func isPeerIdentity(identity string) bool {
+	return strings.HasPrefix(identity, PeerUIDPrefix) || strings.HasPrefix(identity, PeerGIDPrefix)
+}
+
+// Record the peer credentials of a Unix domain socket connection in the context of its requests
+func peerCredContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		log.Warningf("Cannot get peer credentials of Unix domain socket connection: %s", err)
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
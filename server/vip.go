@@ -0,0 +1,264 @@
+package server
+
+////
+//// Virtual IPs shared by groups of containers (e.g. active/standby pairs)
+////
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	agent "github.com/OpenPlatformSDN/nuage-cni/agent/server"
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+	"github.com/gorilla/mux"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+const (
+	VIPPath = "/nuage/vips/" // Agent server relative path for Virtual IPs
+)
+
+var (
+	// Declared VIPs
+	// Key: VIP Name
+	VIPs = make(map[string]*vsdclient.VIP)
+
+	// XXX - Held for the whole duration of VIP operations, including the VSD calls. Serializes VIP moves.
+	vipsmutex sync.Mutex
+)
+
+func init() {
+	// Move VIPs away from containers that are gone
+	containerCleanups = append(containerCleanups, failoverVIPs)
+}
+
+// Declare a VIP and attach it to its active member. Any existing VIP with the same name is replaced
+func putVIP(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	vip := vsdclient.VIP{}
+	if err := json.NewDecoder(req.Body).Decode(&vip); err != nil {
+		log.Errorf("VIP create request - JSON decoding error: %s", err)
+		agent.Sendjson(w, bambou.NewBambouError(VIPCannotCreate+vars["name"], "JSON decoding error"), http.StatusBadRequest)
+		return
+	}
+	vip.Name = vars["name"]
+
+	if len(vip.Members) == 0 {
+		log.Errorf("VIP create request error: VIP: %s has no members", vip.Name)
+		agent.Sendjson(w, bambou.NewBambouError(VIPCannotCreate+vip.Name, "VIP has no members"), http.StatusBadRequest)
+		return
+	}
+
+	// By default the first member holds the VIP
+	active := vip.Active
+	if active == "" {
+		active = vip.Members[0]
+	}
+
+	if !isMember(&vip, active) {
+		log.Errorf("VIP create request error: Container: %s is not a member of VIP: %s", active, vip.Name)
+		agent.Sendjson(w, bambou.NewBambouError(VIPCannotCreate+vip.Name, "Active container is not a VIP member: "+active), http.StatusBadRequest)
+		return
+	}
+
+	// The tenant defaults to the one the active member is placed in, if cached
+	placementsmutex.Lock()
+	if p, exists := Placements[active]; exists && vip.Enterprise == "" && vip.Domain == "" {
+		vip.Enterprise, vip.Domain = p.Enterprise, p.Domain
+	}
+	placementsmutex.Unlock()
+
+	if err := vip.Validate(req.Context()); err != nil {
+		log.Errorf("VIP create request error: %s", err)
+		agent.Sendjson(w, err, http.StatusBadRequest)
+		return
+	}
+
+	vipsmutex.Lock()
+	defer vipsmutex.Unlock()
+
+	if oldvip, exists := VIPs[vip.Name]; exists {
+		if err := detachVIP(req.Context(), oldvip); err != nil {
+			agent.Sendjson(w, bambou.NewBambouError(VIPCannotCreate+vip.Name, err.Error()), http.StatusConflict)
+			return
+		}
+		delete(VIPs, vip.Name)
+	}
+
+	vip.Active, vip.VPortID, vip.ID = "", "", ""
+	if err := attachVIP(req.Context(), &vip, active); err != nil {
+		log.Errorf("VIP create request error: %s", err)
+		agent.Sendjson(w, err, http.StatusConflict)
+		return
+	}
+
+	VIPs[vip.Name] = &vip
+
+	log.Infof("Successfully created VIP: %s", vip.Name)
+	agent.Sendjson(w, vip, http.StatusCreated)
+}
+
+// List all VIPs
+func getVIPs(w http.ResponseWriter, req *http.Request) {
+	log.Info("Serving list of VIPs")
+	vipsmutex.Lock()
+	defer vipsmutex.Unlock()
+
+	var resp []vsdclient.VIP
+	for _, vip := range VIPs {
+		resp = append(resp, *vip)
+	}
+	agent.Sendjson(w, resp, http.StatusOK)
+}
+
+// Get VIP with given Name
+func getVIP(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	vipsmutex.Lock()
+	defer vipsmutex.Unlock()
+
+	if vip, exists := VIPs[vars["name"]]; exists {
+		log.Infof("Serving VIP: %s", vip.Name)
+		agent.Sendjson(w, vip, http.StatusOK)
+	} else {
+		log.Warningf("Cannot find VIP: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(VIPNotFound+vars["name"], ""), http.StatusNotFound)
+	}
+}
+
+// Move a VIP to a given member
+func putVIPActive(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	vipsmutex.Lock()
+	defer vipsmutex.Unlock()
+
+	vip, exists := VIPs[vars["name"]]
+	if !exists {
+		log.Warningf("Cannot find VIP: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(VIPNotFound+vars["name"], ""), http.StatusNotFound)
+		return
+	}
+
+	if !isMember(vip, vars["member"]) {
+		log.Errorf("VIP move request error: Container: %s is not a member of VIP: %s", vars["member"], vip.Name)
+		agent.Sendjson(w, bambou.NewBambouError(VIPCannotMove+vip.Name, "Container is not a VIP member: "+vars["member"]), http.StatusBadRequest)
+		return
+	}
+
+	if vip.Active == vars["member"] {
+		agent.Sendjson(w, vip, http.StatusOK)
+		return
+	}
+
+	previous := vip.Active
+	if err := detachVIP(req.Context(), vip); err != nil {
+		agent.Sendjson(w, bambou.NewBambouError(VIPCannotMove+vip.Name, err.Error()), http.StatusConflict)
+		return
+	}
+
+	if err := attachVIP(req.Context(), vip, vars["member"]); err != nil {
+		log.Errorf("VIP move request error: %s", err)
+		// Best effort: Put it back where it was
+		if previous != "" {
+			attachVIP(req.Context(), vip, previous)
+		}
+		agent.Sendjson(w, bambou.NewBambouError(VIPCannotMove+vip.Name, err.Error()), http.StatusConflict)
+		return
+	}
+
+	log.Infof("Successfully moved VIP: %s from Container: %s to Container: %s", vip.Name, previous, vip.Active)
+	agent.Sendjson(w, vip, http.StatusOK)
+}
+
+// Delete a VIP
+func deleteVIP(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	vipsmutex.Lock()
+	defer vipsmutex.Unlock()
+
+	vip, exists := VIPs[vars["name"]]
+	if !exists {
+		log.Warningf("Cannot find VIP: %s", vars["name"])
+		agent.Sendjson(w, bambou.NewBambouError(VIPNotFound+vars["name"], ""), http.StatusNotFound)
+		return
+	}
+
+	if err := detachVIP(req.Context(), vip); err != nil {
+		agent.Sendjson(w, bambou.NewBambouError(VIPCannotDelete+vip.Name, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	delete(VIPs, vip.Name)
+	log.Infof("Successfully deleted VIP: %s", vip.Name)
+	agent.Sendjson(w, nil, http.StatusOK)
+}
+
+////////
+//////// Util
+////////
+
+func isMember(vip *vsdclient.VIP, name string) bool {
+	for _, member := range vip.Members {
+		if member == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the VIPs held by a container that is gone to the first of their remaining members that can take it
+func failoverVIPs(ctx context.Context, name string) {
+	vipsmutex.Lock()
+	defer vipsmutex.Unlock()
+
+	for _, vip := range VIPs {
+		if vip.Active != name {
+			continue
+		}
+
+		// The VSD VirtualIP may have gone away with the container VPort
+		if err := detachVIP(ctx, vip); err != nil {
+			log.Warningf("Failed to detach VIP: %s from Container: %s. Error: %s", vip.Name, name, err)
+			vip.Active, vip.VPortID, vip.ID = "", "", ""
+		}
+
+		for _, member := range vip.Members {
+			if member == name {
+				continue
+			}
+			if err := attachVIP(ctx, vip, member); err == nil {
+				break
+			}
+		}
+
+		if vip.Active == "" {
+			log.Errorf("VIP: %s has no active member after Container: %s is gone", vip.Name, name)
+		} else {
+			log.Infof("VIP: %s moved from Container: %s to Container: %s", vip.Name, name, vip.Active)
+		}
+	}
+}
+
+// Attach a VIP to a given member, recording the resulting VSD operation
+func attachVIP(ctx context.Context, vip *vsdclient.VIP, member string) error {
+	if err := vip.Attach(ctx, member); err != nil {
+		return err
+	}
+	auditVSD(ctx, "Created Virtual IP: %s (ID: %s) on VPort: %s of Container: %s", vip.VirtualIP, vip.ID, vip.VPortID, member)
+	return nil
+}
+
+// Detach a VIP from its active member, recording the resulting VSD operation
+func detachVIP(ctx context.Context, vip *vsdclient.VIP) error {
+	id, vport, active := vip.ID, vip.VPortID, vip.Active
+	if err := vip.Detach(ctx); err != nil {
+		return err
+	}
+	if id != "" {
+		auditVSD(ctx, "Deleted Virtual IP: %s (ID: %s) from VPort: %s of Container: %s", vip.VirtualIP, id, vport, active)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+////
+//// Graceful shutdown at SIGTERM / SIGINT
+////
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/server"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
+
+	vsdclient "github.com/OpenPlatformSDN/nuage-oci-agent/vsd-client"
+)
+
+// Process exit codes at shutdown. A second signal while shutting down exits right away with 128 + signal number
+const (
+	exitClean      = 0 // In-flight requests and VSD operations drained, local state saved
+	exitIncomplete = 1 // Draining did not complete by the deadline, or the local state could not be saved
+)
+
+// Run the agent server until a listener fails or a SIGTERM / SIGINT is received, then shut it down. Returns the process exit code
+func serve() int {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Server(runningConfig()) }()
+
+	var sig os.Signal
+	select {
+	case err := <-errs:
+		osExit("Failed to start OCI agent server", err)
+	case sig = <-sigs:
+	}
+
+	// The configuration may have been reloaded while serving
+	conf := runningConfig()
+
+	timeout := time.Duration(conf.Lifecycle.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = config.DefaultShutdownTimeout * time.Second
+	}
+	glog.Infof("Received %s. Shutting down, draining in-flight requests for up to: %s", sig, timeout)
+
+	// Give up on draining at a second signal
+	go func() {
+		sig := <-sigs
+		glog.Errorf("Received %s while shutting down. Exiting right away", sig)
+		glog.Flush()
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	code := exitClean
+	if err := server.Shutdown(ctx, conf); err != nil {
+		glog.Errorf("Agent server shutdown incomplete: %s", err)
+		code = exitIncomplete
+	}
+
+	vsdclient.Close()
+	trace.SetExporter(nil)
+
+	glog.Infof("===> %s stopped. Exit code: %d", path.Base(os.Args[0]), code)
+	return code
+}
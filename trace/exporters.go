@@ -0,0 +1,79 @@
+package trace
+
+////
+//// Local span exporters, for environments without a trace collector: One JSON object per span, on stdout or in a file
+////
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Span exporters selectable in the configuration, by name
+const (
+	ExporterNone   = ""
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Writes the spans as JSON lines
+type writerExporter struct {
+	w     io.Writer
+	c     io.Closer // Nil: Not closed
+	mutex sync.Mutex
+}
+
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+// Append the spans to a given file
+func NewFileExporter(file string) (Exporter, error) {
+	os.MkdirAll(filepath.Dir(file), 0755)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &writerExporter{w: f, c: f}, nil
+}
+
+// Exporter of a given name. Nil for none
+func NewExporter(name, file string) (Exporter, error) {
+	switch name {
+	case ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout), nil
+	case ExporterFile:
+		if file == "" {
+			return nil, fmt.Errorf("No span file for the file span exporter")
+		}
+		return NewFileExporter(file)
+	}
+	return nil, fmt.Errorf("Invalid span exporter: %s. Valid exporters are: stdout, file", name)
+}
+
+func (e *writerExporter) Export(span *Span) {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+
+	e.mutex.Lock()
+	e.w.Write(append(data, '\n'))
+	e.mutex.Unlock()
+}
+
+func (e *writerExporter) Close() error {
+	if e.c == nil {
+		return nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.c.Close()
+}
@@ -0,0 +1,109 @@
+package trace
+
+////
+//// Request tracing: Request IDs and timed spans carried in a "context.Context", exported to a pluggable span exporter
+////
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Span: A timed operation within a request, e.g. the whole agent API call or a single VSD call
+type Span struct {
+	RequestID  string            `json:"request_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration_ns"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Receives the completed spans. Must be safe for concurrent use
+type Exporter interface {
+	Export(span *Span)
+	Close() error
+}
+
+var (
+	exporter      Exporter
+	exportermutex sync.RWMutex
+)
+
+// Set the span exporter. Nil: Spans are not recorded. The previous exporter (if any) is closed
+func SetExporter(e Exporter) {
+	exportermutex.Lock()
+	previous := exporter
+	exporter = e
+	exportermutex.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+}
+
+type requestIDKey struct{}
+type spanKey struct{}
+
+// Context carrying a given request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Request ID carried by a context. Empty if none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Random request ID
+func NewID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Start a span, as a child of the current span of the context (if any). Attributes are given as key / value pairs.
+// The returned context carries the new span
+func Start(ctx context.Context, name string, attributes ...string) (context.Context, *Span) {
+	span := &Span{
+		RequestID: RequestID(ctx),
+		SpanID:    NewID(),
+		Name:      name,
+		Start:     time.Now(),
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.ParentID = parent.SpanID
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		span.SetAttribute(attributes[i], attributes[i+1])
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (span *Span) SetAttribute(key, value string) {
+	if span.Attributes == nil {
+		span.Attributes = make(map[string]string)
+	}
+	span.Attributes[key] = value
+}
+
+// Complete a span, with the error it ended with (if any), and export it
+func (span *Span) End(err error) {
+	span.Duration = time.Since(span.Start)
+	if err != nil {
+		span.Error = err.Error()
+	}
+
+	exportermutex.RLock()
+	defer exportermutex.RUnlock()
+	if exporter != nil {
+		exporter.Export(span)
+	}
+}
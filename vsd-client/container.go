@@ -1,60 +1,64 @@
 package vsdclient
 
 import (
+	"context"
 	"encoding/json"
 
-	"github.com/golang/glog"
-
 	"github.com/nuagenetworks/go-bambou/bambou"
 	"github.com/nuagenetworks/vspk-go/vspk"
 )
 
 // XXX -- All those methods rely on a configured VSD connection:
 // - "root" object
-// - valid "Enterprise" and "Domain" set
+// - valid tenants set
 
-func (container *Container) FetchByName() error {
+func (container *Container) FetchByName(ctx context.Context) error {
 	vsdmutex.Lock()
 	defer vsdmutex.Unlock()
 
 	// XXX - We are not locally caching pods (ephemeral constructs)
 
 	// Check the VSD. If it's there, update the local cache and return it
-	containerlist, err := Domain.Containers(&bambou.FetchingInfo{Filter: "name == \"" + container.Name + "\""})
+	// XXX - Container names are unique on a given node (agent cache key), so we look them up across all the tenants
+	var containerlist vspk.ContainersList
+	err := vsdCall(ctx, "Container", "list", func() (err *bambou.Error) {
+		containerlist, err = root.Containers(&bambou.FetchingInfo{Filter: "name == \"" + container.Name + "\""})
+		return
+	})
 
 	if err != nil {
 		return bambou.NewBambouError("Cannot fetch Container with name: "+container.Name, err.Error())
 	}
 
 	if len(containerlist) == 1 {
-		glog.Infof("Container with name: %s found on VSD", container.Name)
+		log.Infof("Container with name: %s found on VSD", container.Name)
 		*container = (Container)(*containerlist[0])
 	}
 
 	return nil
 }
 
-func (container *Container) Create() error {
+func (container *Container) Create(ctx context.Context) error {
 	vsdmutex.Lock()
 	defer vsdmutex.Unlock()
 
-	if err := root.CreateContainer((*vspk.Container)(container)); err != nil {
+	if err := vsdCall(ctx, "Container", "create", func() *bambou.Error { return root.CreateContainer((*vspk.Container)(container)) }); err != nil {
 		return bambou.NewBambouError("Cannot create Container with name: "+container.Name, err.Error())
 	}
 
-	glog.Infof("Container with name: %s created on the VSD", container.Name)
+	log.Infof("Container with name: %s created on the VSD", container.Name)
 	return nil
 }
 
-func (container *Container) Delete() error {
+func (container *Container) Delete(ctx context.Context) error {
 	vsdmutex.Lock()
 	defer vsdmutex.Unlock()
 
-	if err := (*vspk.Container)(container).Delete(); err != nil {
+	if err := vsdCall(ctx, "Container", "delete", (*vspk.Container)(container).Delete); err != nil {
 		return bambou.NewBambouError("Cannot delete Container with name: "+container.Name, err.Error())
 	}
 
-	glog.Infof("Container with name: %s successfully deleted from the VSD", container.Name)
+	log.Infof("Container with name: %s successfully deleted from the VSD", container.Name)
 	return nil
 }
 
@@ -65,7 +69,7 @@ func (container *Container) Delete() error {
 // - No need to reach to the VSD, so no need for Mutex locking
 func (container *Container) IPandMask() (string, string) {
 	if len(container.Interfaces) != 1 {
-		glog.Fatalf("Given container does not have exactly one interface. Container info: %#v", container)
+		log.Fatalf("Given container does not have exactly one interface. Container info: %#v", container)
 	}
 
 	//XXX - "container.Interfaces[0]" is "map[string]interface{}" (arbitrary JSON object) instead of a "ContainerInterface"
@@ -76,4 +80,103 @@ func (container *Container) IPandMask() (string, string) {
 	return ciface.IPAddress, ciface.Netmask
 }
 
-//
+// Fetch the VSD interface of a container. If it has several interfaces, it only uses the first one
+// XXX - Assumes the Container ID is set (e.g. via "FetchByName")
+func (container *Container) Interface(ctx context.Context) (*vspk.ContainerInterface, error) {
+	cifaces, err := container.FetchInterfaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cifaces) == 0 {
+		return nil, bambou.NewBambouError("Cannot fetch interfaces of Container with name: "+container.Name, "Container has no interfaces")
+	}
+
+	return cifaces[0], nil
+}
+
+// Fetch all the VSD interfaces of a container. Empty if none
+// XXX - Assumes the Container ID is set (e.g. via "FetchByName")
+func (container *Container) FetchInterfaces(ctx context.Context) (vspk.ContainerInterfacesList, error) {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	var cifaces vspk.ContainerInterfacesList
+	err := vsdCall(ctx, "ContainerInterface", "list", func() (err *bambou.Error) {
+		cifaces, err = (*vspk.Container)(container).ContainerInterfaces(nil)
+		return
+	})
+	if err != nil {
+		return nil, bambou.NewBambouError("Cannot fetch interfaces of Container with name: "+container.Name, err.Error())
+	}
+
+	return cifaces, nil
+}
+
+// Find the VSD VPort of a container, using the VPortID of its interface.
+// XXX - Assumes the Container ID is set (e.g. via "FetchByName")
+func (container *Container) VPort(ctx context.Context) (*vspk.VPort, error) {
+	ciface, err := container.Interface(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	if ciface.VPortID == "" {
+		return nil, bambou.NewBambouError("Cannot find VPort of Container with name: "+container.Name, "Container interface has no VPort")
+	}
+
+	vport := vspk.NewVPort()
+	vport.ID = ciface.VPortID
+	if err := vsdCall(ctx, "VPort", "fetch", vport.Fetch); err != nil {
+		return nil, bambou.NewBambouError("Cannot fetch VPort of Container with name: "+container.Name, err.Error())
+	}
+
+	return vport, nil
+}
+
+// Set the IP and MAC addresses of the container interface in the (not yet created) container metadata.
+// - If the container has no interface information, one is created
+// - No need to reach to the VSD, so no need for Mutex locking
+func (container *Container) SetIPandMAC(ip, mac string) {
+	ciface := container.iface()
+	ciface.IPAddress = ip
+	ciface.MAC = mac
+	container.setIface(ciface)
+}
+
+// Set the IP address of the container interface in the (not yet created) container metadata, keeping its MAC address -- or generating one if none.
+// - No need to reach to the VSD, so no need for Mutex locking
+func (container *Container) SetIP(ip string) {
+	ciface := container.iface()
+	ciface.IPAddress = ip
+	if ciface.MAC == "" {
+		ciface.MAC = GenerateMAC()
+	}
+	container.setIface(ciface)
+}
+
+////////
+//////// utils
+////////
+
+// Interface information in the container metadata. Empty if none.
+// XXX - Same SDK workaround as for "IPandMask": The interface is an arbitrary JSON object, so we (un)marshall it into a "ContainerInterface".
+func (container *Container) iface() vspk.ContainerInterface {
+	ciface := vspk.ContainerInterface{}
+	if len(container.Interfaces) > 0 {
+		data, _ := json.Marshal(container.Interfaces[0])
+		json.Unmarshal(data, &ciface)
+	}
+	return ciface
+}
+
+// Set the interface information in the container metadata. If the container has no interface information, one is created
+func (container *Container) setIface(ciface vspk.ContainerInterface) {
+	if len(container.Interfaces) == 0 {
+		container.Interfaces = make([]interface{}, 1)
+	}
+	container.Interfaces[0] = ciface
+}
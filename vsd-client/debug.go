@@ -0,0 +1,125 @@
+package vsdclient
+
+////
+//// Debug view of the VSD client: Session, resolved tenant IDs and the Zones / Subnets known to the VSD
+////
+
+import (
+	"context"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// A VSD object, by name and ID
+type NamedID struct {
+	Name string `json:"name"`
+	ID   string `json:"ID"`
+}
+
+// A Subnet of a tenant Domain -- or the tenant L2Domain itself
+type SubnetState struct {
+	Name        string `json:"name"`
+	ID          string `json:"ID"`
+	Zone        string `json:"zone,omitempty"` // Zone name. Empty for L2Domains
+	Address     string `json:"address,omitempty"`
+	Netmask     string `json:"netmask,omitempty"`
+	DHCPManaged bool   `json:"DHCPManaged"` // Whether the VSD manages the IP addresses. Always set for L3 Subnets
+}
+
+// Resolved tenant, with the Zones and Subnets of its Domain (or its L2Domain)
+type TenantState struct {
+	Enterprise NamedID       `json:"enterprise"`
+	Domain     *NamedID      `json:"domain,omitempty"`
+	L2Domain   *NamedID      `json:"l2domain,omitempty"`
+	Zones      []NamedID     `json:"zones,omitempty"`
+	Subnets    []SubnetState `json:"subnets,omitempty"`
+	Error      string        `json:"error,omitempty"` // Zones / Subnets could not be fetched
+}
+
+// Debug view of the VSD client
+type ClientState struct {
+	URL         string        `json:"url"`
+	Session     SessionState  `json:"session"`
+	LastEventID string        `json:"lastEventID,omitempty"` // Last push notification received
+	Tenants     []TenantState `json:"tenants"`
+}
+
+// Current state of the VSD client. Zones and Subnets are fetched from the VSD
+func State(ctx context.Context) *ClientState {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	state := &ClientState{Session: Session(), LastEventID: LastEventID(), Tenants: []TenantState{}}
+	connmutex.RLock()
+	if mysession != nil {
+		state.URL = mysession.URL
+	}
+	connmutex.RUnlock()
+
+	for _, tenant := range allTenants() {
+		ts := TenantState{Enterprise: NamedID{Name: tenant.Enterprise.Name, ID: tenant.Enterprise.ID}}
+
+		if tenant.L2Domain != nil {
+			ts.L2Domain = &NamedID{Name: tenant.L2Domain.Name, ID: tenant.L2Domain.ID}
+			ts.Subnets = []SubnetState{{
+				Name:        tenant.L2Domain.Name,
+				ID:          tenant.L2Domain.ID,
+				Address:     tenant.L2Domain.Address,
+				Netmask:     tenant.L2Domain.Netmask,
+				DHCPManaged: tenant.L2Domain.DHCPManaged,
+			}}
+			state.Tenants = append(state.Tenants, ts)
+			continue
+		}
+
+		ts.Domain = &NamedID{Name: tenant.Domain.Name, ID: tenant.Domain.ID}
+		if err := tenant.fetchTopology(ctx, &ts); err != nil {
+			ts.Error = err.Error()
+		}
+		state.Tenants = append(state.Tenants, ts)
+	}
+
+	return state
+}
+
+////////
+//////// utils
+////////
+
+// Fetch the Zones and Subnets of the tenant Domain
+func (tenant *Tenant) fetchTopology(ctx context.Context, ts *TenantState) error {
+	var zl vspk.ZonesList
+	if err := vsdCall(ctx, "Zone", "list", func() (err *bambou.Error) {
+		zl, err = tenant.Domain.Zones(nil)
+		return
+	}); err != nil {
+		return err
+	}
+
+	zones := make(map[string]string) // Zone name by ID
+	for _, zone := range zl {
+		ts.Zones = append(ts.Zones, NamedID{Name: zone.Name, ID: zone.ID})
+		zones[zone.ID] = zone.Name
+	}
+
+	var sl vspk.SubnetsList
+	if err := vsdCall(ctx, "Subnet", "list", func() (err *bambou.Error) {
+		sl, err = tenant.Domain.Subnets(nil)
+		return
+	}); err != nil {
+		return err
+	}
+
+	for _, subnet := range sl {
+		ts.Subnets = append(ts.Subnets, SubnetState{
+			Name:        subnet.Name,
+			ID:          subnet.ID,
+			Zone:        zones[subnet.ParentID],
+			Address:     subnet.Address,
+			Netmask:     subnet.Netmask,
+			DHCPManaged: true,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package vsdclient
+
+////
+//// VSD call metrics and tracing, plus session metrics
+////
+
+import (
+	"context"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/metrics"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/trace"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+var (
+	vsdRequests = metrics.NewCounterVec("vsd_requests_total", "VSD API calls, by entity, operation and result", "entity", "operation", "result")
+	vsdLatency  = metrics.NewHistogramVec("vsd_request_duration_seconds", "VSD API call latency, by entity and operation", metrics.DefBuckets, "entity", "operation")
+)
+
+func init() {
+	metrics.NewGaugeFunc("vsd_session_up", "Whether the VSD session is established (1) or not (0)", func() float64 {
+		if Session().Up {
+			return 1
+		}
+		return 0
+	})
+
+	metrics.NewGaugeFunc("vsd_tenants", "Number of resolved Enterprise / Domain (or L2Domain) tenants", func() float64 {
+		return float64(Session().Tenants)
+	})
+}
+
+// Run a VSD call, recording its count, latency and result by entity (e.g. "VPort") and operation (e.g. "fetch"), plus a span of the request in the context.
+// Serialized against the replacement of the VSD session, see "Reconnect"
+func vsdCall(ctx context.Context, entity, operation string, call func() *bambou.Error) *bambou.Error {
+	connmutex.RLock()
+	defer connmutex.RUnlock()
+
+	if closed {
+		return bambou.NewBambouError("Cannot "+operation+" "+entity+" on the VSD", "VSD session closed")
+	}
+	return sessionCall(ctx, entity, operation, call)
+}
+
+// "vsdCall", without "connmutex". VSD session failures mark the session down
+// XXX - Callers must hold "connmutex", or not depend on the current VSD session (e.g. a long poll on a session of its own)
+func sessionCall(ctx context.Context, entity, operation string, call func() *bambou.Error) *bambou.Error {
+	_, span := trace.Start(ctx, "vsd."+entity+"."+operation, "entity", entity, "operation", operation)
+	err := call()
+
+	result := "success"
+	if err != nil {
+		result = "error"
+		span.End(err)
+	} else {
+		span.End(nil)
+	}
+
+	checkSession(err)
+
+	vsdRequests.Inc(entity, operation, result)
+	vsdLatency.Observe(span.Duration.Seconds(), entity, operation)
+
+	if log.Enabled(logging.DebugLevel) {
+		log.With("vsd_op", entity+"."+operation, "duration", span.Duration.String(), "request_id", trace.RequestID(ctx), "error", err).
+			Debugf("VSD call: %s %s -- %s", operation, entity, result)
+	}
+	return err
+}
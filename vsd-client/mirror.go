@@ -0,0 +1,147 @@
+package vsdclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Valid mirroring directions, as per VSD "VPortMirror.mirrorDirection"
+const (
+	MirrorIngress = "INGRESS"
+	MirrorEgress  = "EGRESS"
+	MirrorBoth    = "BOTH"
+)
+
+// Port mirroring session for the VPort of a container
+type Mirror struct {
+	Container   string    `json:"container"`             // Container Name
+	Destination string    `json:"destination"`           // Name of a "MirrorDestination" or, for overlay mirroring, the ID of an "OverlayMirrorDestination"
+	Overlay     bool      `json:"overlay"`               // Overlay mirroring (via "OverlayMirrorDestination") instead of "MirrorDestination"
+	Direction   string    `json:"direction,omitempty"`   // INGRESS, EGRESS or BOTH. Not applicable for overlay mirroring
+	Expiry      string    `json:"expiry,omitempty"`      // Requested lifetime, as a Go duration (e.g. "30m")
+	Expires     time.Time `json:"expires"`               // When the mirror is automatically torn down
+	VPortID     string    `json:"vportID,omitempty"`     // VPort of the container
+	VPortMirror string    `json:"vportMirror,omitempty"` // ID of the resulting "VPortMirror". Empty for overlay mirroring
+}
+
+// Set up the mirroring on the VSD.
+// XXX - For overlay mirroring the container VPort is added to the VPorts of the "OverlayMirrorDestination". The direction is given by the destination.
+func (mirror *Mirror) Create(ctx context.Context) error {
+	container := &Container{Name: mirror.Container}
+	if err := container.FetchByName(ctx); err != nil {
+		return err
+	}
+
+	if container.ID == "" {
+		return bambou.NewBambouError("Cannot mirror Container with name: "+mirror.Container, "Container not found on the VSD")
+	}
+
+	vport, err := container.VPort(ctx)
+	if err != nil {
+		return err
+	}
+	mirror.VPortID = vport.ID
+
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	if mirror.Overlay {
+		omd := vspk.NewOverlayMirrorDestination()
+		omd.ID = mirror.Destination
+		if err := vsdCall(ctx, "OverlayMirrorDestination", "fetch", omd.Fetch); err != nil {
+			return bambou.NewBambouError("Cannot find Overlay Mirror Destination with ID: "+mirror.Destination, err.Error())
+		}
+
+		var vports vspk.VPortsList
+		err := vsdCall(ctx, "VPort", "list", func() (err *bambou.Error) {
+			vports, err = omd.VPorts(nil)
+			return
+		})
+		if err != nil {
+			return bambou.NewBambouError("Cannot fetch VPorts of Overlay Mirror Destination: "+omd.Name, err.Error())
+		}
+
+		if err := vsdCall(ctx, "VPort", "assign", func() *bambou.Error { return omd.AssignVPorts(append(vports, vport)) }); err != nil {
+			return bambou.NewBambouError("Cannot mirror Container with name: "+mirror.Container, err.Error())
+		}
+
+		log.Infof("Container with name: %s mirrored to Overlay Mirror Destination: %s", mirror.Container, omd.Name)
+		return nil
+	}
+
+	vpm := vspk.NewVPortMirror()
+	var mdl vspk.MirrorDestinationsList
+	if err := vsdCall(ctx, "MirrorDestination", "list", func() (err *bambou.Error) {
+		mdl, err = root.MirrorDestinations(&bambou.FetchingInfo{Filter: "name == \"" + mirror.Destination + "\""})
+		return
+	}); err != nil {
+		return bambou.NewBambouError("Error fetching list of Mirror Destinations from the VSD", err.Error())
+	} else {
+		if len(mdl) != 1 {
+			return bambou.NewBambouError("Cannot find Mirror Destination: "+mirror.Destination, "Mirror Destination not found")
+		}
+		vpm.MirrorDestinationID = mdl[0].ID
+	}
+
+	vpm.MirrorDirection = mirror.Direction
+	if err := vsdCall(ctx, "VPortMirror", "create", func() *bambou.Error { return vport.CreateVPortMirror(vpm) }); err != nil {
+		return bambou.NewBambouError("Cannot mirror Container with name: "+mirror.Container, err.Error())
+	}
+	mirror.VPortMirror = vpm.ID
+
+	log.Infof("Container with name: %s mirrored to Mirror Destination: %s, direction: %s", mirror.Container, mirror.Destination, mirror.Direction)
+	return nil
+}
+
+// Tear down the mirroring on the VSD.
+func (mirror *Mirror) Delete(ctx context.Context) error {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	if mirror.Overlay {
+		omd := vspk.NewOverlayMirrorDestination()
+		omd.ID = mirror.Destination
+
+		var vports vspk.VPortsList
+		err := vsdCall(ctx, "VPort", "list", func() (err *bambou.Error) {
+			vports, err = omd.VPorts(nil)
+			return
+		})
+		if notfounderr(err) {
+			log.Infof("Overlay Mirror Destination with ID: %s of Container with name: %s already removed", mirror.Destination, mirror.Container)
+			return nil
+		}
+		if err != nil {
+			return bambou.NewBambouError("Cannot fetch VPorts of Overlay Mirror Destination with ID: "+mirror.Destination, err.Error())
+		}
+
+		var remaining vspk.VPortsList
+		for _, vport := range vports {
+			if vport.ID != mirror.VPortID {
+				remaining = append(remaining, vport)
+			}
+		}
+
+		if err := vsdCall(ctx, "VPort", "assign", func() *bambou.Error { return omd.AssignVPorts(remaining) }); err != nil {
+			return bambou.NewBambouError("Cannot remove mirroring of Container with name: "+mirror.Container, err.Error())
+		}
+
+		log.Infof("Removed overlay mirroring of Container with name: %s", mirror.Container)
+		return nil
+	}
+
+	vpm := vspk.NewVPortMirror()
+	vpm.ID = mirror.VPortMirror
+	if err := vsdCall(ctx, "VPortMirror", "delete", vpm.Delete); notfounderr(err) {
+		log.Infof("Mirroring of Container with name: %s already removed", mirror.Container)
+		return nil
+	} else if err != nil {
+		return bambou.NewBambouError("Cannot remove mirroring of Container with name: "+mirror.Container, err.Error())
+	}
+
+	log.Infof("Removed mirroring of Container with name: %s", mirror.Container)
+	return nil
+}
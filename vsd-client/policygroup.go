@@ -0,0 +1,89 @@
+package vsdclient
+
+import (
+	"context"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Fetch the Policy Groups with the given names in the tenant Domain (or L2Domain). Error if any of them is not found
+func (tenant *Tenant) GetPolicyGroups(ctx context.Context, names []string) (vspk.PolicyGroupsList, error) {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	return tenant.policyGroups(ctx, names)
+}
+
+// Assign the Policy Groups with the given names to the VPort of a container, replacing the Policy Groups it is currently part of.
+// XXX - Assumes the Container ID is set (e.g. via "FetchByName")
+func (container *Container) AssignPolicyGroups(ctx context.Context, names []string) error {
+	vport, err := container.VPort(ctx)
+	if err != nil {
+		return err
+	}
+
+	tenant := vportTenant(vport)
+	if tenant == nil {
+		return bambou.NewBambouError("Cannot assign Policy Groups to Container with name: "+container.Name, "Container is not part of a local tenant Domain or L2Domain")
+	}
+
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	pgl, err := tenant.policyGroups(ctx, names)
+	if err != nil {
+		return err
+	}
+
+	if err := vsdCall(ctx, "PolicyGroup", "assign", func() *bambou.Error { return vport.AssignPolicyGroups(pgl) }); err != nil {
+		return bambou.NewBambouError("Cannot assign Policy Groups to Container with name: "+container.Name, err.Error())
+	}
+
+	log.Infof("VPort of Container: %s assigned to Policy Groups: %v", container.Name, names)
+	return nil
+}
+
+////////
+//////// utils
+////////
+
+// XXX - Callers must hold "vsdmutex"
+func (tenant *Tenant) policyGroups(ctx context.Context, names []string) (vspk.PolicyGroupsList, error) {
+	var pgl vspk.PolicyGroupsList
+
+	for _, name := range names {
+		var found vspk.PolicyGroupsList
+		info := &bambou.FetchingInfo{Filter: "name == \"" + name + "\""}
+		if err := vsdCall(ctx, "PolicyGroup", "list", func() (err *bambou.Error) {
+			if tenant.L2Domain != nil {
+				found, err = tenant.L2Domain.PolicyGroups(info)
+			} else {
+				found, err = tenant.Domain.PolicyGroups(info)
+			}
+			return
+		}); err != nil {
+			return nil, bambou.NewBambouError("Cannot fetch Policy Group with name: "+name, err.Error())
+		}
+
+		if len(found) != 1 {
+			return nil, bambou.NewBambouError("Cannot find Policy Group with name: "+name, "Policy Group not found in the Domain (or L2Domain) of the container")
+		}
+		pgl = append(pgl, found[0])
+	}
+
+	return pgl, nil
+}
+
+// Tenant of a VPort: VPorts of L2Domains are their direct children, VPorts of Domains are children of their Subnets
+func vportTenant(vport *vspk.VPort) *Tenant {
+	if vport.ParentType == vspk.L2DomainIdentity.Name {
+		for _, tenant := range allTenants() {
+			if tenant.L2Domain != nil && tenant.L2Domain.ID == vport.ParentID {
+				return tenant
+			}
+		}
+		return nil
+	}
+	return GetTenantByDomainID(vport.DomainID)
+}
@@ -0,0 +1,191 @@
+package vsdclient
+
+////
+//// VSD push notifications: Changes made to VSD objects, e.g. by an operator in the VSD GUI
+////
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/metrics"
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+// VSD push event types
+const (
+	PushCreate = "CREATE"
+	PushUpdate = "UPDATE"
+	PushDelete = "DELETE"
+)
+
+const (
+	pushRetry       = 5 * time.Second // Delay before polling again after a failure. Doubled at each failure, up to "pushMaxRetry"
+	pushMaxRetry    = 2 * time.Minute
+	pushMaxFailures = 3                // Consecutive failures resuming from the last event ID before resuming without it
+	pushIdle        = 10 * time.Second // Delay before polling again without a VSD session
+)
+
+var pushEvents = metrics.NewCounterVec("vsd_push_events_total", "VSD push notification events received, by entity type and event type", "entity", "type")
+
+var (
+	// Push notification handlers, by entity type (identity name)
+	pushhandlers = make(map[string]bambou.EventHandler)
+
+	// ID of the last notification received. Resuming from it after a reconnect -- or an agent restart -- replays the events missed meanwhile
+	lastEventID string
+
+	pushstop chan struct{}
+
+	pushmutex sync.Mutex
+)
+
+// Register the push notification handler for the VSD objects of a given identity, e.g. "vspk.VPortIdentity". Replaces any previous handler.
+// Same as "bambou.PushCenter.RegisterHandlerForIdentity"
+func RegisterPushHandler(identity bambou.Identity, handler bambou.EventHandler) {
+	pushmutex.Lock()
+	pushhandlers[identity.Name] = handler
+	pushmutex.Unlock()
+}
+
+// ID of the last notification received. Empty if none
+func LastEventID() string {
+	pushmutex.Lock()
+	defer pushmutex.Unlock()
+	return lastEventID
+}
+
+// Resume the push notifications from a given notification ID, e.g. as saved at the previous agent shutdown
+func SetLastEventID(id string) {
+	pushmutex.Lock()
+	lastEventID = id
+	pushmutex.Unlock()
+}
+
+// Start receiving the push notifications, from the last notification ID (if any). Survives VSD reconnects
+// XXX - "bambou.PushCenter" always starts without a notification ID, does not expose the last one, and stops polling after a failed poll.
+// So we poll with "NextEvent" ourselves and dispatch the events the same way
+func StartNotifications() {
+	pushmutex.Lock()
+	defer pushmutex.Unlock()
+
+	if pushstop != nil {
+		return
+	}
+	pushstop = make(chan struct{})
+	go pollNotifications(pushstop)
+}
+
+// Stop receiving the push notifications. The poll in progress (if any) is abandoned
+func StopNotifications() {
+	pushmutex.Lock()
+	defer pushmutex.Unlock()
+
+	if pushstop != nil {
+		close(pushstop)
+		pushstop = nil
+	}
+}
+
+////////
+//////// utils
+////////
+
+func pollNotifications(stop chan struct{}) {
+	retry, failures := pushRetry, 0
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		connmutex.RLock()
+		session := mysession
+		connmutex.RUnlock()
+
+		if session == nil || !Session().Up {
+			if !sleep(stop, pushIdle) {
+				return
+			}
+			continue
+		}
+
+		id := LastEventID()
+		channel := make(bambou.NotificationsChannel, 1)
+
+		// XXX - Long poll: Returns once there are events, or when the VSD times out the poll. Not serialized against the replacement of the VSD session, which would wait for the poll
+		if err := sessionCall(context.Background(), "Event", "poll", func() *bambou.Error { return session.NextEvent(channel, id) }); err != nil {
+			failures++
+			log.Warningf("Failed to receive VSD push notifications (attempt: %d, after notification: %q): %s", failures, id, err)
+
+			// The last notification ID may be unknown to the VSD, e.g. after a long agent downtime
+			if id != "" && failures >= pushMaxFailures {
+				log.Warningf("!!! Resuming VSD push notifications without the last notification ID: %s. VSD changes made meanwhile are missed !!!", id)
+				SetLastEventID("")
+			}
+
+			if !sleep(stop, retry) {
+				return
+			}
+			if retry *= 2; retry > pushMaxRetry {
+				retry = pushMaxRetry
+			}
+			continue
+		}
+		retry, failures = pushRetry, 0
+
+		select {
+		case notification := <-channel:
+			// Abandoned while polling
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			dispatch(notification)
+			SetLastEventID(notification.UUID)
+		default:
+		}
+	}
+}
+
+// Run the handlers of the events of a notification
+func dispatch(notification *bambou.Notification) {
+	for _, event := range notification.Events {
+		pushEvents.Inc(event.EntityType, event.Type)
+		if len(event.DataMap) == 0 {
+			continue
+		}
+
+		// Same as "bambou.PushCenter": The (first) entity, as JSON
+		buffer := &bytes.Buffer{}
+		if err := json.NewEncoder(buffer).Encode(event.DataMap[0]); err != nil {
+			continue
+		}
+		event.Data = buffer.Bytes()
+
+		pushmutex.Lock()
+		handler, exists := pushhandlers[event.EntityType]
+		pushmutex.Unlock()
+
+		if exists {
+			log.Debugf("VSD push notification: %s %s", event.Type, event.EntityType)
+			handler(event)
+		}
+	}
+}
+
+// Sleep for a given duration. False if stopped meanwhile
+func sleep(stop chan struct{}, d time.Duration) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,265 @@
+package vsdclient
+
+import (
+	"context"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Name of the Domain ingress forwarding policy holding the redirection rules managed by this agent
+const FwdPolicyName = "nuage-oci-agent"
+
+// Valid forwarding rule match types, as per VSD "IngressAdvFwdEntryTemplate.locationType" / "networkType"
+const (
+	MatchAny         = "ANY"
+	MatchZone        = "ZONE"
+	MatchSubnet      = "SUBNET"
+	MatchPolicyGroup = "POLICYGROUP"
+)
+
+// Source or destination of traffic matched by a forwarding rule
+type Match struct {
+	Type string `json:"type"`           // ANY, ZONE, SUBNET or POLICYGROUP
+	Name string `json:"name,omitempty"` // Name of the Zone, Subnet or Policy Group. Empty for ANY
+}
+
+// Forwarding rule redirecting the matching traffic to a redirection target
+type FwdRule struct {
+	Source      Match  `json:"source"`
+	Destination Match  `json:"destination"`
+	Priority    int    `json:"priority,omitempty"`
+	ID          string `json:"ID,omitempty"` // ID of the VSD "IngressAdvFwdEntryTemplate"
+}
+
+// Redirection target backed by the VPort of a named container (e.g. a firewall container), plus the forwarding rules steering traffic to it
+// The Zones, Subnets and Policy Groups of the forwarding rules are resolved in the Domain of the Redirection Target tenant
+type RedirectionTarget struct {
+	Name       string     `json:"name"`              // Redirection Target Name
+	Enterprise string     `json:"enterprise"`        // Enterprise Name of the tenant
+	Domain     string     `json:"domain"`            // Domain Name of the tenant
+	Container  string     `json:"container"`         // Name of the backing container
+	Rules      []*FwdRule `json:"rules"`             // Forwarding rules
+	VPortID    string     `json:"vportID,omitempty"` // VPort of the backing container
+	ID         string     `json:"ID,omitempty"`      // ID of the VSD "RedirectionTarget"
+}
+
+// Tenant of the Redirection Target. Nil if it does not match local configuration
+func (rt *RedirectionTarget) Tenant() *Tenant {
+	return GetTenant(rt.Enterprise, rt.Domain)
+}
+
+// Check the match type is valid and the Zone, Subnet or Policy Group it refers to exists in the tenant Domain
+func (match *Match) Validate(ctx context.Context, tenant *Tenant) error {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	_, err := match.locationID(ctx, tenant)
+	return err
+}
+
+// Converge the VSD state of the redirection target towards the local declaration:
+// - The VSD Redirection Target exists and the VPort of the (running) backing container is assigned to it
+// - The forwarding rules exist in the agent's ingress forwarding policy, as declared
+// VSD objects are only re-created when not found. Other VSD errors fail the convergence, which is retried later
+// XXX - Assumes the backing container ID is set (e.g. via "FetchByName")
+func (rt *RedirectionTarget) Converge(ctx context.Context, container *Container) error {
+	vport, err := container.VPort(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The Redirection Target and the forwarding rules live in the Domain of the Redirection Target tenant, which must be the one of the backing container
+	tenant := rt.Tenant()
+	if tenant == nil {
+		return bambou.NewBambouError("Cannot converge Redirection Target: "+rt.Name, "Enterprise: "+rt.Enterprise+" and Domain: "+rt.Domain+" do not match local configuration")
+	}
+	if vport.DomainID != tenant.Domain.ID {
+		return bambou.NewBambouError("Cannot converge Redirection Target: "+rt.Name, "Container: "+rt.Container+" is not part of Domain: "+rt.Domain)
+	}
+
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	vsdrt := vspk.NewRedirectionTarget()
+	vsdrt.ID = rt.ID
+	var fetcherr *bambou.Error
+	if rt.ID != "" {
+		if fetcherr = vsdCall(ctx, "RedirectionTarget", "fetch", vsdrt.Fetch); fetcherr != nil && !notfounderr(fetcherr) {
+			return bambou.NewBambouError("Cannot fetch Redirection Target: "+rt.Name, fetcherr.Error())
+		}
+	}
+	if rt.ID == "" || fetcherr != nil {
+		vsdrt = vspk.NewRedirectionTarget()
+		vsdrt.Name = rt.Name
+		vsdrt.Description = "Backed by Container: " + rt.Container
+		if err := vsdCall(ctx, "RedirectionTarget", "create", func() *bambou.Error { return tenant.Domain.CreateRedirectionTarget(vsdrt) }); err != nil {
+			return bambou.NewBambouError("Cannot create Redirection Target: "+rt.Name, err.Error())
+		}
+		rt.ID = vsdrt.ID
+		log.Infof("Redirection Target: %s created on the VSD", rt.Name)
+	}
+
+	var vports vspk.VPortsList
+	if err := vsdCall(ctx, "VPort", "list", func() (err *bambou.Error) {
+		vports, err = vsdrt.VPorts(nil)
+		return
+	}); err != nil {
+		return bambou.NewBambouError("Cannot fetch VPorts of Redirection Target: "+rt.Name, err.Error())
+	} else {
+		if len(vports) != 1 || vports[0].ID != vport.ID {
+			if err := vsdCall(ctx, "VPort", "assign", func() *bambou.Error { return vsdrt.AssignVPorts(vspk.VPortsList{vport}) }); err != nil {
+				return bambou.NewBambouError("Cannot assign VPort of Container: "+rt.Container+" to Redirection Target: "+rt.Name, err.Error())
+			}
+			log.Infof("VPort of Container: %s assigned to Redirection Target: %s", rt.Container, rt.Name)
+		}
+		rt.VPortID = vport.ID
+	}
+
+	policy, err := fwdPolicy(ctx, tenant.Domain)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rt.Rules {
+		entry := vspk.NewIngressAdvFwdEntryTemplate()
+		entry.Action = "REDIRECT"
+		entry.RedirectVPortTagID = rt.ID
+		entry.Protocol = "ANY"
+		entry.Priority = rule.Priority
+		entry.Description = "Redirect to: " + rt.Name
+		entry.LocationType = rule.Source.Type
+		entry.NetworkType = rule.Destination.Type
+
+		if entry.LocationID, err = rule.Source.locationID(ctx, tenant); err != nil {
+			return err
+		}
+		if entry.NetworkID, err = rule.Destination.locationID(ctx, tenant); err != nil {
+			return err
+		}
+
+		if rule.ID != "" {
+			actual := vspk.NewIngressAdvFwdEntryTemplate()
+			actual.ID = rule.ID
+			err := vsdCall(ctx, "IngressAdvFwdEntryTemplate", "fetch", actual.Fetch)
+			if err != nil && !notfounderr(err) {
+				return bambou.NewBambouError("Cannot fetch forwarding rule for Redirection Target: "+rt.Name, err.Error())
+			}
+			if err == nil {
+				if sameFwdEntry(actual, entry) {
+					continue
+				}
+
+				// Changed on the VSD -- or in the declaration: Updated in place
+				actual.Action, actual.RedirectVPortTagID, actual.Protocol, actual.Priority, actual.Description = entry.Action, entry.RedirectVPortTagID, entry.Protocol, entry.Priority, entry.Description
+				actual.LocationType, actual.LocationID, actual.NetworkType, actual.NetworkID = entry.LocationType, entry.LocationID, entry.NetworkType, entry.NetworkID
+				if err := vsdCall(ctx, "IngressAdvFwdEntryTemplate", "update", actual.Save); err != nil {
+					return bambou.NewBambouError("Cannot update forwarding rule for Redirection Target: "+rt.Name, err.Error())
+				}
+				log.Infof("Forwarding rule from %s: %s to %s: %s redirected to: %s updated on the VSD", rule.Source.Type, rule.Source.Name, rule.Destination.Type, rule.Destination.Name, rt.Name)
+				continue
+			}
+		}
+
+		if err := vsdCall(ctx, "IngressAdvFwdEntryTemplate", "create", func() *bambou.Error { return policy.CreateIngressAdvFwdEntryTemplate(entry) }); err != nil {
+			return bambou.NewBambouError("Cannot create forwarding rule for Redirection Target: "+rt.Name, err.Error())
+		}
+		rule.ID = entry.ID
+		log.Infof("Forwarding rule from %s: %s to %s: %s redirected to: %s created on the VSD", rule.Source.Type, rule.Source.Name, rule.Destination.Type, rule.Destination.Name, rt.Name)
+	}
+
+	return nil
+}
+
+// Delete the forwarding rules and the Redirection Target from the VSD.
+func (rt *RedirectionTarget) Delete(ctx context.Context) error {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	for _, rule := range rt.Rules {
+		if rule.ID == "" {
+			continue
+		}
+		entry := vspk.NewIngressAdvFwdEntryTemplate()
+		entry.ID = rule.ID
+		if err := vsdCall(ctx, "IngressAdvFwdEntryTemplate", "delete", entry.Delete); err != nil {
+			return bambou.NewBambouError("Cannot delete forwarding rule for Redirection Target: "+rt.Name, err.Error())
+		}
+		rule.ID = ""
+	}
+
+	if rt.ID != "" {
+		vsdrt := vspk.NewRedirectionTarget()
+		vsdrt.ID = rt.ID
+		if err := vsdCall(ctx, "RedirectionTarget", "delete", vsdrt.Delete); err != nil {
+			return bambou.NewBambouError("Cannot delete Redirection Target: "+rt.Name, err.Error())
+		}
+		rt.ID = ""
+		rt.VPortID = ""
+	}
+
+	log.Infof("Redirection Target: %s successfully deleted from the VSD", rt.Name)
+	return nil
+}
+
+////////
+//////// utils
+////////
+
+// Find -- or create -- the ingress forwarding policy managed by this agent in a given Domain
+// XXX - Needs the vsdmutex held by the caller
+func fwdPolicy(ctx context.Context, domain *vspk.Domain) (*vspk.IngressAdvFwdTemplate, error) {
+	var pl vspk.IngressAdvFwdTemplatesList
+	err := vsdCall(ctx, "IngressAdvFwdTemplate", "list", func() (err *bambou.Error) {
+		pl, err = domain.IngressAdvFwdTemplates(&bambou.FetchingInfo{Filter: "name == \"" + FwdPolicyName + "\""})
+		return
+	})
+	if err != nil {
+		return nil, bambou.NewBambouError("Error fetching list of Ingress Forwarding Policies from the VSD", err.Error())
+	}
+
+	if len(pl) == 1 {
+		return pl[0], nil
+	}
+
+	policy := vspk.NewIngressAdvFwdTemplate()
+	policy.Name = FwdPolicyName
+	policy.Active = true
+	if err := vsdCall(ctx, "IngressAdvFwdTemplate", "create", func() *bambou.Error { return domain.CreateIngressAdvFwdTemplate(policy) }); err != nil {
+		return nil, bambou.NewBambouError("Cannot create Ingress Forwarding Policy: "+FwdPolicyName, err.Error())
+	}
+
+	log.Infof("Ingress Forwarding Policy: %s created in Domain: %s", FwdPolicyName, domain.Name)
+	return policy, nil
+}
+
+// Whether a VSD forwarding rule matches the declared one. Priorities not declared are the ones assigned by the VSD
+func sameFwdEntry(actual, declared *vspk.IngressAdvFwdEntryTemplate) bool {
+	return actual.Action == declared.Action && actual.RedirectVPortTagID == declared.RedirectVPortTagID && actual.Protocol == declared.Protocol && (declared.Priority == 0 || actual.Priority == declared.Priority) &&
+		actual.LocationType == declared.LocationType && actual.LocationID == declared.LocationID && actual.NetworkType == declared.NetworkType && actual.NetworkID == declared.NetworkID
+}
+
+// VSD ID of the Zone, Subnet or Policy Group of a match, in the tenant Domain
+// XXX - Needs the vsdmutex held by the caller
+func (match *Match) locationID(ctx context.Context, tenant *Tenant) (string, error) {
+	switch match.Type {
+	case MatchAny:
+		return "", nil
+	case MatchZone:
+		if zone := tenant.GetZone(ctx, match.Name); zone != nil {
+			return zone.ID, nil
+		}
+	case MatchSubnet:
+		if subnet := tenant.GetSubnet(ctx, match.Name); subnet != nil {
+			return subnet.ID, nil
+		}
+	case MatchPolicyGroup:
+		if pgl, err := tenant.policyGroups(ctx, []string{match.Name}); err == nil {
+			return pgl[0].ID, nil
+		}
+	default:
+		return "", bambou.NewBambouError("Invalid match type: "+match.Type, "Valid types are: ANY, ZONE, SUBNET, POLICYGROUP")
+	}
+
+	return "", bambou.NewBambouError("Cannot find "+match.Type+": "+match.Name, match.Type+" not found in Domain: "+tenant.Domain.Name)
+}
@@ -0,0 +1,121 @@
+package vsdclient
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Suffix of the "externalID" of the VSD IP Reservations managed by this agent. The prefix is the container name.
+const ReservationExternalID = "@nuage-oci-agent"
+
+// Sticky IP address of a named container, backed by a VSD "IPReservation" in the container Subnet
+type Reservation struct {
+	Name       string `json:"name"`               // Container Name
+	Enterprise string `json:"enterprise"`         // Enterprise Name of the tenant
+	Domain     string `json:"domain"`             // Domain Name of the tenant
+	Subnet     string `json:"subnet"`             // Subnet Name
+	IPAddress  string `json:"IPAddress"`          // Reserved IP address
+	MAC        string `json:"MAC"`                // MAC address the IP address is reserved for. Re-used for the container interface
+	SubnetID   string `json:"subnetID,omitempty"` // ID of the VSD "Subnet"
+	ID         string `json:"ID,omitempty"`       // ID of the VSD "IPReservation"
+}
+
+// Create the IP Reservation on the VSD, in the Subnet of the reservation tenant
+func (reservation *Reservation) Create(ctx context.Context) error {
+	tenant := GetTenant(reservation.Enterprise, reservation.Domain)
+	if tenant == nil {
+		return bambou.NewBambouError("Cannot create IP Reservation for Container with name: "+reservation.Name, "Enterprise: "+reservation.Enterprise+" and Domain: "+reservation.Domain+" do not match local configuration")
+	}
+
+	subnet := tenant.GetSubnet(ctx, reservation.Subnet)
+	if subnet == nil {
+		return bambou.NewBambouError("Cannot create IP Reservation for Container with name: "+reservation.Name, "Cannot find Subnet: "+reservation.Subnet+" in Domain: "+reservation.Domain)
+	}
+
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	ipr := vspk.NewIPReservation()
+	ipr.IPAddress = reservation.IPAddress
+	ipr.MAC = reservation.MAC
+	ipr.ExternalID = reservation.Name + ReservationExternalID
+
+	if err := vsdCall(ctx, "IPReservation", "create", func() *bambou.Error { return subnet.CreateIPReservation(ipr) }); err != nil {
+		return bambou.NewBambouError("Cannot create IP Reservation for Container with name: "+reservation.Name, err.Error())
+	}
+	reservation.ID = ipr.ID
+	reservation.SubnetID = subnet.ID
+
+	log.Infof("IP Reservation for Container with name: %s created on the VSD. Subnet: %s, IP: %s, MAC: %s", reservation.Name, reservation.Subnet, reservation.IPAddress, reservation.MAC)
+	return nil
+}
+
+// Delete the IP Reservation from the VSD.
+func (reservation *Reservation) Delete(ctx context.Context) error {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	ipr := vspk.NewIPReservation()
+	ipr.ID = reservation.ID
+	if err := vsdCall(ctx, "IPReservation", "delete", ipr.Delete); err != nil {
+		return bambou.NewBambouError("Cannot delete IP Reservation for Container with name: "+reservation.Name, err.Error())
+	}
+
+	log.Infof("IP Reservation for Container with name: %s successfully deleted from the VSD", reservation.Name)
+	return nil
+}
+
+// Fetch all the IP Reservations managed by this agent in the Subnets of the tenant Domains, as identified by their "externalID"
+func FetchReservations(ctx context.Context) ([]*Reservation, error) {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	var reservations []*Reservation
+	for _, tenant := range allTenants() {
+		// XXX - IP Reservations are only available in L3 Domain Subnets
+		if tenant.Domain == nil {
+			continue
+		}
+
+		var sl vspk.SubnetsList
+		err := vsdCall(ctx, "Subnet", "list", func() (err *bambou.Error) {
+			sl, err = tenant.Domain.Subnets(nil)
+			return
+		})
+		if err != nil {
+			return nil, bambou.NewBambouError("Error fetching list of Subnets in Domain: "+tenant.Domain.Name+" from the VSD", err.Error())
+		}
+
+		for _, subnet := range sl {
+			var iprl vspk.IPReservationsList
+			err := vsdCall(ctx, "IPReservation", "list", func() (err *bambou.Error) {
+				iprl, err = subnet.IPReservations(nil)
+				return
+			})
+			if err != nil {
+				return nil, bambou.NewBambouError("Error fetching list of IP Reservations for Subnet: "+subnet.Name, err.Error())
+			}
+
+			for _, ipr := range iprl {
+				if !strings.HasSuffix(ipr.ExternalID, ReservationExternalID) {
+					continue
+				}
+				reservations = append(reservations, &Reservation{
+					Name:       strings.TrimSuffix(ipr.ExternalID, ReservationExternalID),
+					Enterprise: tenant.Enterprise.Name,
+					Domain:     tenant.Domain.Name,
+					Subnet:     subnet.Name,
+					IPAddress:  ipr.IPAddress,
+					MAC:        ipr.MAC,
+					SubnetID:   subnet.ID,
+					ID:         ipr.ID,
+				})
+			}
+		}
+	}
+
+	return reservations, nil
+}
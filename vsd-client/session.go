@@ -0,0 +1,137 @@
+package vsdclient
+
+////
+//// VSD session state, as reported in the agent health and metrics
+////
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+const (
+	sessionProbe = 30 * time.Second // Interval of the VSD session checks: Liveness while up, re-establishment while down
+)
+
+// State of the VSD session
+type SessionState struct {
+	Up           bool `json:"up"`           // Session established
+	Reconnecting bool `json:"reconnecting"` // Session being re-established, e.g. at configuration reload
+	Tenants      int  `json:"tenants"`      // Number of resolved Enterprise / Domain (or L2Domain) tenants
+
+	Since time.Time `json:"since"` // When the session was (re-)established. Zero if down
+}
+
+var (
+	session      SessionState
+	sessionmutex sync.Mutex
+
+	probestop chan struct{} // See "startProbe"
+)
+
+// Current state of the VSD session
+func Session() SessionState {
+	sessionmutex.Lock()
+	defer sessionmutex.Unlock()
+	return session
+}
+
+// Check the VSD session periodically, see "probeSession". Stopped by "Close"
+// XXX - Callers must hold "connmutex" for writing
+func startProbe() {
+	if probestop != nil {
+		return
+	}
+	probestop = make(chan struct{})
+	go probeSession(probestop)
+}
+
+func stopProbe() {
+	if probestop != nil {
+		close(probestop)
+		probestop = nil
+	}
+}
+
+// Detect a VSD session gone down without VSD traffic, and re-establish it once the VSD is back (e.g. after a VSD restart).
+// The VSD calls failing meanwhile mark it down, see "sessionCall"
+func probeSession(stop chan struct{}) {
+	for sleep(stop, sessionProbe) {
+		if Session().Up {
+			// A fresh root object: The session one is shared by the VSD calls in progress
+			vsdCall(context.Background(), "Me", "fetch", func() *bambou.Error { return vspk.NewMe().Fetch() })
+			continue
+		}
+		restartSession()
+	}
+}
+
+func restartSession() {
+	connmutex.Lock()
+	defer connmutex.Unlock()
+
+	if closed || mysession == nil || Session().Up {
+		return
+	}
+
+	if err := sessionCall(context.Background(), "Session", "start", mysession.Start); err != nil {
+		log.Warningf("Failed to re-establish the VSD session. Retrying in: %s. Error: %s", sessionProbe, err)
+		return
+	}
+	setSessionUp(true)
+	log.Info("Re-established the VSD session")
+}
+
+// Mark the VSD session down after a failed VSD call, if the failure is about the session itself: Transport errors, authentication failures
+func checkSession(err *bambou.Error) {
+	if err == nil || !sessionerr(err) {
+		return
+	}
+
+	sessionmutex.Lock()
+	up := session.Up
+	session.Up, session.Since = false, time.Time{}
+	sessionmutex.Unlock()
+
+	if up {
+		log.Errorf("VSD session down. Error: %s", err)
+	}
+}
+
+// XXX - "go-bambou" gives the HTTP status of the VSD errors other than 404 and 409 as "HTTP error" descriptions, and the transport errors as "HTTP client error"
+func sessionerr(err *bambou.Error) bool {
+	switch err.Title {
+	case "HTTP client error":
+		return true
+	case "HTTP error":
+		return strings.HasPrefix(err.Description, "401") || strings.HasPrefix(err.Description, "403")
+	}
+	return false
+}
+
+func setSessionUp(up bool) {
+	sessionmutex.Lock()
+	session.Up = up
+	session.Since = time.Time{}
+	if up {
+		session.Since = time.Now()
+	}
+	sessionmutex.Unlock()
+}
+
+func setReconnecting(reconnecting bool) {
+	sessionmutex.Lock()
+	session.Reconnecting = reconnecting
+	sessionmutex.Unlock()
+}
+
+func setTenants(n int) {
+	sessionmutex.Lock()
+	session.Tenants = n
+	sessionmutex.Unlock()
+}
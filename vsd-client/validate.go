@@ -0,0 +1,54 @@
+package vsdclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Preflight checks of the VSD prerequisites: VSD reachability and login, existence of the tenant Enterprises and Domains (with Zones) or L2Domains
+func Preflight(conf *config.Config) []config.Check {
+	var checks []config.Check
+	ctx := context.Background()
+
+	err := makeX509conn(ctx, conf)
+	checks = append(checks, config.Check{Name: "VSD reachable and login at: " + conf.Vsd.Url, Err: err})
+	if err != nil {
+		return checks
+	}
+
+	for _, tc := range conf.Vsd.AllTenants() {
+		name := fmt.Sprintf("Enterprise: %s, Domain: %s", tc.Enterprise, tc.Domain)
+		if tc.L2Domain != "" {
+			name = fmt.Sprintf("Enterprise: %s, L2Domain: %s", tc.Enterprise, tc.L2Domain)
+		}
+
+		if tc.Enterprise == "" || (tc.Domain == "") == (tc.L2Domain == "") {
+			checks = append(checks, config.Check{Name: name + " complete", Err: fmt.Errorf("Each tenant needs an Enterprise and either a Domain or an L2Domain")})
+			continue
+		}
+
+		tenant, err := findTenant(ctx, tc)
+		checks = append(checks, config.Check{Name: name + " exist on the VSD", Err: err})
+		if err != nil || tenant.Domain == nil {
+			continue
+		}
+
+		var zl vspk.ZonesList
+		if err := vsdCall(ctx, "Zone", "list", func() (err *bambou.Error) {
+			zl, err = tenant.Domain.Zones(nil)
+			return
+		}); err != nil {
+			checks = append(checks, config.Check{Name: "Zones in Domain: " + tc.Domain, Err: err})
+		} else if len(zl) == 0 {
+			checks = append(checks, config.Check{Name: "Zones in Domain: " + tc.Domain, Err: fmt.Errorf("Domain has no Zones")})
+		} else {
+			checks = append(checks, config.Check{Name: fmt.Sprintf("Zones in Domain: %s (%d found)", tc.Domain, len(zl)), Err: nil})
+		}
+	}
+
+	return checks
+}
@@ -0,0 +1,102 @@
+package vsdclient
+
+import (
+	"context"
+	"net"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// Virtual IP shared by a group of containers. At any given time it is attached to the VPort of a single (active) member.
+type VIP struct {
+	Name       string   `json:"name"`               // VIP Name
+	Enterprise string   `json:"enterprise"`         // Enterprise Name of the tenant
+	Domain     string   `json:"domain"`             // Domain Name of the tenant
+	Subnet     string   `json:"subnet"`             // Subnet Name
+	VirtualIP  string   `json:"virtualIP"`          // Virtual IP address. Must be part of the Subnet
+	Members    []string `json:"members"`            // Names of the containers sharing the VIP, in order of preference
+	Active     string   `json:"active,omitempty"`   // Name of the container currently holding the VIP
+	SubnetID   string   `json:"subnetID,omitempty"` // ID of the VSD "Subnet"
+	VPortID    string   `json:"vportID,omitempty"`  // VPort of the active container
+	ID         string   `json:"ID,omitempty"`       // ID of the VSD "VirtualIP" on that VPort
+}
+
+// Check the VIP address is part of its Subnet, in the Domain of the VIP tenant
+func (vip *VIP) Validate(ctx context.Context) error {
+	tenant := GetTenant(vip.Enterprise, vip.Domain)
+	if tenant == nil {
+		return bambou.NewBambouError("Invalid VIP: "+vip.Name, "Enterprise: "+vip.Enterprise+" and Domain: "+vip.Domain+" do not match local configuration")
+	}
+
+	subnet := tenant.GetSubnet(ctx, vip.Subnet)
+	if subnet == nil {
+		return bambou.NewBambouError("Invalid VIP: "+vip.Name, "Cannot find Subnet: "+vip.Subnet+" in Domain: "+vip.Domain)
+	}
+
+	ip := net.ParseIP(vip.VirtualIP)
+	prefix := net.IPNet{IP: net.ParseIP(subnet.Address), Mask: net.IPMask(net.ParseIP(subnet.Netmask).To4())}
+	if ip == nil || !prefix.Contains(ip) {
+		return bambou.NewBambouError("Invalid VIP: "+vip.Name, "Virtual IP: "+vip.VirtualIP+" is not part of Subnet: "+vip.Subnet)
+	}
+	vip.SubnetID = subnet.ID
+
+	return nil
+}
+
+// Attach the VIP to the VPort of the given member container.
+// XXX - Assumes the VIP is not currently attached (see "Detach")
+func (vip *VIP) Attach(ctx context.Context, member string) error {
+	container := &Container{Name: member}
+	if err := container.FetchByName(ctx); err != nil {
+		return err
+	}
+
+	if container.ID == "" {
+		return bambou.NewBambouError("Cannot attach VIP: "+vip.Name+" to Container with name: "+member, "Container not found on the VSD")
+	}
+
+	vport, err := container.VPort(ctx)
+	if err != nil {
+		return err
+	}
+
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	vsdvip := vspk.NewVirtualIP()
+	vsdvip.VirtualIP = vip.VirtualIP
+	if err := vsdCall(ctx, "VirtualIP", "create", func() *bambou.Error { return vport.CreateVirtualIP(vsdvip) }); err != nil {
+		return bambou.NewBambouError("Cannot attach VIP: "+vip.Name+" to Container with name: "+member, err.Error())
+	}
+
+	vip.Active = member
+	vip.VPortID = vport.ID
+	vip.ID = vsdvip.ID
+
+	log.Infof("VIP: %s (%s) attached to Container with name: %s", vip.Name, vip.VirtualIP, member)
+	return nil
+}
+
+// Detach the VIP from the VPort of its active member, if any
+func (vip *VIP) Detach(ctx context.Context) error {
+	if vip.ID == "" {
+		return nil
+	}
+
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	vsdvip := vspk.NewVirtualIP()
+	vsdvip.ID = vip.ID
+	if err := vsdCall(ctx, "VirtualIP", "delete", vsdvip.Delete); err != nil {
+		return bambou.NewBambouError("Cannot detach VIP: "+vip.Name+" from Container with name: "+vip.Active, err.Error())
+	}
+
+	log.Infof("VIP: %s (%s) detached from Container with name: %s", vip.Name, vip.VirtualIP, vip.Active)
+
+	vip.Active = ""
+	vip.VPortID = ""
+	vip.ID = ""
+	return nil
+}
@@ -1,16 +1,22 @@
 package vsdclient
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
-
 	"github.com/OpenPlatformSDN/nuage-oci-agent/config"
+	"github.com/OpenPlatformSDN/nuage-oci-agent/logging"
 
 	"github.com/nuagenetworks/go-bambou/bambou"
 	"github.com/nuagenetworks/vspk-go/vspk"
@@ -21,87 +27,189 @@ const (
 )
 
 var (
+	log = logging.For(logging.VSDClient)
+
 	// Nuage API connection defaults. We need to keep them as global vars since commands can be invoked in whatever order.
 
 	root      *vspk.Me
 	mysession *bambou.Session
 
-	// Nuage Enterprise and Domain for OCI containers. They must exist.
-	Enterprise *vspk.Enterprise
-	Domain     *vspk.Domain
+	// Guard of the VSD session: Read locked by the VSD calls -- see "vsdCall" -- and write locked while the session is replaced or closed
+	connmutex sync.RWMutex
+	closed    bool // VSD session closed, see "Close"
+
+	// Nuage Enterprise and Domain (or L2Domain) pairs (tenants) for OCI containers. They must exist.
+	// Replaced as a whole at (re-)initialization: Readers take a snapshot -- see "allTenants"
+	tenants      []*Tenant
+	tenantsmutex sync.RWMutex
 
 	// Serialize VSD operations, esp creates/updates
 	vsdmutex sync.Mutex
 )
 
+// Tenant context: A Nuage Enterprise and one of its Domains or L2Domains. Exactly one of "Domain" and "L2Domain" is set
+type Tenant struct {
+	Enterprise *vspk.Enterprise
+	Domain     *vspk.Domain
+	L2Domain   *vspk.L2Domain
+}
+
 func InitClient(conf *config.Config) error {
+	connmutex.Lock()
+	defer connmutex.Unlock()
 
-	if err := makeX509conn(conf); err != nil {
-		return bambou.NewBambouError("Nuage TLS API connection failed", err.Error())
+	if err := initClient(conf); err != nil {
+		return err
 	}
+	startProbe()
+	return nil
+}
 
-	if conf.Vsd.Enterprise == "" || conf.Vsd.Domain == "" {
-		return bambou.NewBambouError("Nuage VSD Enterprise and/or Domain are absent from configuration file", "")
-	}
+// Re-initialize the VSD client with a new configuration, e.g. at configuration reload. On failure the current VSD session and tenants are kept.
+// The VSD calls in progress complete on the current session, the ones made meanwhile wait for the new one
+func Reconnect(conf *config.Config) error {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
 
-	//// Find the  Enterprise and Domain. They must be pre-existing in the VSD.
+	connmutex.Lock()
+	defer connmutex.Unlock()
 
-	//// VSD Enterprise
-	if el, err := root.Enterprises(&bambou.FetchingInfo{Filter: "name == \"" + conf.Vsd.Enterprise + "\""}); err != nil {
-		return bambou.NewBambouError("Error fetching list of Enterprises from the VSD", err.Error())
-	} else {
-		if len(el) != 1 { // Given Enterprise doesn't exist
-			return bambou.NewBambouError("Cannot find VSD Enterprise: "+conf.Vsd.Enterprise, "VSD Enterprise not found")
+	// Not ready until reconnected
+	setReconnecting(true)
+	defer setReconnecting(false)
+
+	oldsession, oldroot := mysession, root
+
+	// XXX - The tenants are only replaced once all of them are found, so they are kept as is on failure
+	if err := initClient(conf); err != nil {
+		mysession, root = oldsession, oldroot
+		// XXX - "bambou" keeps track of the current session globally, so we need to re-start the previous one
+		if mysession != nil {
+			if err := sessionCall(context.Background(), "Session", "start", mysession.Start); err != nil {
+				log.Errorf("Failed to re-establish the previous VSD session: %s", err)
+				setSessionUp(false)
+			} else {
+				setSessionUp(true)
+			}
 		}
+		return err
+	}
 
-		Enterprise = el[0]
-		glog.Infof("Found existing Enterprise: %#s", Enterprise.Name)
+	return nil
+}
+
+// Close the VSD session, e.g. at shutdown. Waits for the VSD calls in progress (if any), later ones fail.
+// XXX - Agent operations made of several VSD calls (e.g. container create) are only waited for by draining the agent API requests first, see "server.Shutdown"
+func Close() {
+	vsdmutex.Lock()
+	defer vsdmutex.Unlock()
+
+	connmutex.Lock()
+	defer connmutex.Unlock()
+
+	if mysession != nil {
+		mysession.Reset()
 	}
+	closed = true
+	stopProbe()
+	setSessionUp(false)
+	log.Info("VSD session closed")
+}
 
-	////  VSD Domain
-	if dl, err := root.Domains(&bambou.FetchingInfo{Filter: "name == \"" + conf.Vsd.Domain + "\""}); err != nil {
-		return bambou.NewBambouError("Error fetching list of Domains from the VSD", err.Error())
-	} else {
-		if len(dl) != 1 {
-			return bambou.NewBambouError("Cannot find VSD Domain: "+conf.Vsd.Domain, "VSD Domain not found")
+// Get the Tenant context for a given Enterprise and Domain name.  Return nil if not found.
+func GetTenant(enterprise, domain string) *Tenant {
+	for _, tenant := range allTenants() {
+		if tenant.Domain != nil && tenant.Enterprise.Name == enterprise && tenant.Domain.Name == domain {
+			return tenant
 		}
+	}
+	return nil
+}
 
-		Domain = dl[0]
-		glog.Infof("Found existing Domain: %#s", Domain.Name)
+// Get the Tenant context for a given Enterprise and L2Domain name.  Return nil if not found.
+func GetL2Tenant(enterprise, l2domain string) *Tenant {
+	for _, tenant := range allTenants() {
+		if tenant.L2Domain != nil && tenant.Enterprise.Name == enterprise && tenant.L2Domain.Name == l2domain {
+			return tenant
+		}
 	}
+	return nil
+}
 
-	glog.Info("VSD client initialization completed")
+// Get the Tenant context for a given Domain ID.  Return nil if not found.
+func GetTenantByDomainID(id string) *Tenant {
+	for _, tenant := range allTenants() {
+		if tenant.Domain != nil && tenant.Domain.ID == id {
+			return tenant
+		}
+	}
 	return nil
 }
 
-// Get Zone.  Return nil if not found.
-func GetZone(zname string) *vspk.Zone {
-	if zl, err := root.Zones(&bambou.FetchingInfo{Filter: "name == \"" + zname + "\""}); err != nil {
-		glog.Errorf("Error fetching list of Zones from the VSD: %s", err)
+// Get Zone in the Tenant Domain.  Return nil if not found.
+func (tenant *Tenant) GetZone(ctx context.Context, zname string) *vspk.Zone {
+	var zl vspk.ZonesList
+	if err := vsdCall(ctx, "Zone", "list", func() (err *bambou.Error) {
+		zl, err = tenant.Domain.Zones(&bambou.FetchingInfo{Filter: "name == \"" + zname + "\""})
+		return
+	}); err != nil {
+		log.Errorf("Error fetching list of Zones in Domain: %s from the VSD: %s", tenant.Domain.Name, err)
 		return nil
 	} else {
 		if len(zl) != 1 {
-			glog.Errorf("Cannot find Zone: %s", zname)
+			log.Errorf("Cannot find Zone: %s in Domain: %s", zname, tenant.Domain.Name)
 			return nil
 		}
 		return zl[0]
 	}
 }
 
-// Get Subnet.  Return nil if not found.
-func GetSubnet(sname string) *vspk.Subnet {
-	if sl, err := root.Subnets(&bambou.FetchingInfo{Filter: "name == \"" + sname + "\""}); err != nil {
-		glog.Errorf("Error fetching list of Subnets from the VSD: %s", err)
+// Get Subnet in the Tenant Domain.  Return nil if not found.
+func (tenant *Tenant) GetSubnet(ctx context.Context, sname string) *vspk.Subnet {
+	var sl vspk.SubnetsList
+	if err := vsdCall(ctx, "Subnet", "list", func() (err *bambou.Error) {
+		sl, err = tenant.Domain.Subnets(&bambou.FetchingInfo{Filter: "name == \"" + sname + "\""})
+		return
+	}); err != nil {
+		log.Errorf("Error fetching list of Subnets in Domain: %s from the VSD: %s", tenant.Domain.Name, err)
 		return nil
 	} else {
 		if len(sl) != 1 {
-			glog.Errorf("Cannot find Subnet: %s", sname)
+			log.Errorf("Cannot find Subnet: %s in Domain: %s", sname, tenant.Domain.Name)
 			return nil
 		}
 		return sl[0]
 	}
 }
 
+// Validate -- and adjust -- the addressing of a container about to be created in the Tenant L2Domain:
+// - DHCP managed: The VSD allocates the IP address. A static IP address in the container metadata must be part of the L2Domain address range
+// - Unmanaged: The VSD does no IPAM. Any IP address information in the container metadata is removed, i.e. left to the container runtime
+func (tenant *Tenant) L2Addressing(container *Container) error {
+	ciface := container.iface()
+
+	if !tenant.L2Domain.DHCPManaged {
+		if ciface.IPAddress != "" {
+			log.Warningf("L2Domain: %s is not DHCP managed. Ignoring IP address: %s of Container: %s", tenant.L2Domain.Name, ciface.IPAddress, container.Name)
+		}
+		ciface.IPAddress, ciface.Netmask, ciface.Gateway = "", "", ""
+		container.setIface(ciface)
+		return nil
+	}
+
+	if ciface.IPAddress == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(ciface.IPAddress)
+	prefix := net.IPNet{IP: net.ParseIP(tenant.L2Domain.Address), Mask: net.IPMask(net.ParseIP(tenant.L2Domain.Netmask).To4())}
+	if ip == nil || !prefix.Contains(ip) {
+		return bambou.NewBambouError("Invalid IP address for Container with name: "+container.Name, "IP address: "+ciface.IPAddress+" is not part of L2Domain: "+tenant.L2Domain.Name)
+	}
+
+	return nil
+}
+
 func GenerateMAC() string {
 	buf := make([]byte, 6)
 	rand.Seed(time.Now().UTC().UnixNano())
@@ -118,27 +226,215 @@ func GenerateMAC() string {
 //////// utils
 ////////
 
+// Establish the VSD session and find the tenants
+// XXX - Callers must hold "connmutex" for writing
+func initClient(conf *config.Config) error {
+	ctx := context.Background()
+
+	if err := makeX509conn(ctx, conf); err != nil {
+		return bambou.NewBambouError("Nuage TLS API connection failed", err.Error())
+	}
+
+	tcs := conf.Vsd.AllTenants()
+	if len(tcs) == 0 {
+		return bambou.NewBambouError("Nuage VSD Enterprise and/or Domain are absent from configuration file", "")
+	}
+
+	//// Find the Enterprises and Domains. They must be pre-existing in the VSD. The current tenants are served until all of them are found
+	var found []*Tenant
+	for _, tc := range tcs {
+		if tc.Enterprise == "" || (tc.Domain == "") == (tc.L2Domain == "") {
+			return bambou.NewBambouError("Nuage VSD Enterprise and/or Domain are absent from configuration file", fmt.Sprintf("Each tenant needs an Enterprise and either a Domain or an L2Domain: %#v", tc))
+		}
+
+		tenant, err := findTenant(ctx, tc)
+		if err != nil {
+			return err
+		}
+		found = append(found, tenant)
+	}
+
+	setAllTenants(found)
+	log.Info("VSD client initialization completed")
+	return nil
+}
+
+// Find the VSD Enterprise and Domain of a tenant
+// XXX - Callers must hold "connmutex" for writing, or be the only user of the VSD session (e.g. preflight checks)
+func findTenant(ctx context.Context, tc config.TenantConfig) (*Tenant, error) {
+	tenant := &Tenant{}
+
+	//// VSD Enterprise
+	var el vspk.EnterprisesList
+	if err := sessionCall(ctx, "Enterprise", "list", func() (err *bambou.Error) {
+		el, err = root.Enterprises(&bambou.FetchingInfo{Filter: "name == \"" + tc.Enterprise + "\""})
+		return
+	}); err != nil {
+		return nil, bambou.NewBambouError("Error fetching list of Enterprises from the VSD", err.Error())
+	} else {
+		if len(el) != 1 { // Given Enterprise doesn't exist
+			return nil, bambou.NewBambouError("Cannot find VSD Enterprise: "+tc.Enterprise, "VSD Enterprise not found")
+		}
+
+		tenant.Enterprise = el[0]
+		log.Infof("Found existing Enterprise: %s", tenant.Enterprise.Name)
+	}
+
+	////  VSD L2Domain
+	if tc.L2Domain != "" {
+		var dl vspk.L2DomainsList
+		if err := sessionCall(ctx, "L2Domain", "list", func() (err *bambou.Error) {
+			dl, err = tenant.Enterprise.L2Domains(&bambou.FetchingInfo{Filter: "name == \"" + tc.L2Domain + "\""})
+			return
+		}); err != nil {
+			return nil, bambou.NewBambouError("Error fetching list of L2Domains from the VSD", err.Error())
+		} else {
+			if len(dl) != 1 {
+				return nil, bambou.NewBambouError("Cannot find VSD L2Domain: "+tc.L2Domain+" in Enterprise: "+tc.Enterprise, "VSD L2Domain not found")
+			}
+
+			tenant.L2Domain = dl[0]
+			log.Infof("Found existing L2Domain: %s. DHCP managed: %t", tenant.L2Domain.Name, tenant.L2Domain.DHCPManaged)
+		}
+
+		return tenant, nil
+	}
+
+	////  VSD Domain
+	var dl vspk.DomainsList
+	if err := sessionCall(ctx, "Domain", "list", func() (err *bambou.Error) {
+		dl, err = tenant.Enterprise.Domains(&bambou.FetchingInfo{Filter: "name == \"" + tc.Domain + "\""})
+		return
+	}); err != nil {
+		return nil, bambou.NewBambouError("Error fetching list of Domains from the VSD", err.Error())
+	} else {
+		if len(dl) != 1 {
+			return nil, bambou.NewBambouError("Cannot find VSD Domain: "+tc.Domain+" in Enterprise: "+tc.Enterprise, "VSD Domain not found")
+		}
+
+		tenant.Domain = dl[0]
+		log.Infof("Found existing Domain: %s", tenant.Domain.Name)
+	}
+
+	return tenant, nil
+}
+
 // Create a connection to the VSD using X.509 certificate-based authentication
-func makeX509conn(conf *config.Config) error {
+// XXX - Callers must hold "connmutex" for writing, or be the only user of the VSD session (e.g. preflight checks)
+func makeX509conn(ctx context.Context, conf *config.Config) error {
 	if cert, err := tls.LoadX509KeyPair(conf.Vsd.CertFile, conf.Vsd.KeyFile); err != nil {
 		return err
 	} else {
+		tc, err := vsdTLSConfig(conf, &cert)
+		if err != nil {
+			return err
+		}
+		if err := verifyVSD(conf, tc); err != nil {
+			return err
+		}
+		// XXX - "go-bambou" makes its requests with a transport of its own, which skips the VSD certificate verification and cannot be replaced from here.
+		// The TLS policy is only enforced by the check of the VSD endpoint above, until a "go-bambou" revision taking a TLS configuration is vendored
 		mysession, root = vspk.NewX509Session(&cert, conf.Vsd.Url)
 	}
 
-	// mysession.SetInsecureSkipVerify(true)
+	if err := sessionCall(ctx, "Session", "start", mysession.Start); err != nil {
+		setSessionUp(false)
+		return err
+	}
+	setSessionUp(true)
 
-	if err := mysession.Start(); err != nil {
+	log.Infof("vsd-client: Successfully established a connection to the VSD at URL is: %s\n", conf.Vsd.Url)
+
+	// log.Infof("vsd-client: Successfuly established bambou session: %#v\n", *mysession)
+
+	return nil
+}
+
+// TLS configuration of the VSD connections, as per the configured TLS policy: TLS version, cipher suites, curves and VSD certificate (CA bundle or pinned fingerprint)
+func vsdTLSConfig(conf *config.Config, cert *tls.Certificate) (*tls.Config, error) {
+	policy := &conf.Vsd.TLS
+
+	u, err := url.Parse(conf.Vsd.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &tls.Config{ServerName: u.Hostname(), Certificates: []tls.Certificate{*cert}}
+	if err := policy.Apply(tc); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case policy.Insecure:
+		log.Warningf("!!! INSECURE: VSD certificate verification is DISABLED for VSD at URL: %s. Use for labs ONLY !!!", conf.Vsd.Url)
+		tc.InsecureSkipVerify = true
+
+	case policy.PinnedFingerprint() != "":
+		// Pinned certificate instead of CA verification
+		fingerprint := policy.PinnedFingerprint()
+		tc.InsecureSkipVerify = true
+		tc.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("VSD presented no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if hex.EncodeToString(sum[:]) != fingerprint {
+				return fmt.Errorf("VSD certificate fingerprint: %s does not match the pinned fingerprint", hex.EncodeToString(sum[:]))
+			}
+			return nil
+		}
+
+	case policy.CAFile != "":
+		data, err := ioutil.ReadFile(policy.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in VSD CA bundle: %s", policy.CAFile)
+		}
+	}
+
+	return tc, nil
+}
+
+// Check the VSD endpoint satisfies a given TLS configuration before establishing a session, reporting policy violations up front
+func verifyVSD(conf *config.Config, tc *tls.Config) error {
+	u, err := url.Parse(conf.Vsd.Url)
+	if err != nil {
 		return err
 	}
 
-	glog.Infof("vsd-client: Successfully established a connection to the VSD at URL is: %s\n", conf.Vsd.Url)
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
 
-	// glog.Infof("vsd-client: Successfuly established bambou session: %#v\n", *mysession)
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", host, tc)
+	if err != nil {
+		return bambou.NewBambouError("VSD TLS policy check failed for VSD at URL: "+conf.Vsd.Url, err.Error())
+	}
+	defer conn.Close()
 
+	state := conn.ConnectionState()
+	log.Infof("VSD at URL: %s satisfies the TLS policy. TLS version: %s, cipher suite: %s", conf.Vsd.Url, tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
 	return nil
 }
 
+// Current tenants. The returned slice is never modified
+func allTenants() []*Tenant {
+	tenantsmutex.RLock()
+	defer tenantsmutex.RUnlock()
+	return tenants
+}
+
+func setAllTenants(found []*Tenant) {
+	tenantsmutex.Lock()
+	tenants = found
+	tenantsmutex.Unlock()
+	setTenants(len(found))
+}
+
 // XXX - Due to VSD create operations delays, simultaneous create operations may fail with "already exists" (particularly at startup).
 // Here we check if the underlying error contains that string (as all "go-bambou" errors of this type should)
 
@@ -148,3 +444,12 @@ func alreadyexistserr(err error) bool {
 	}
 	return false
 }
+
+// XXX - "go-bambou" gives no HTTP status for VSD errors. Here we check if the underlying error is about a missing VSD object (e.g. deleted meanwhile)
+func notfounderr(err error) bool {
+	if be, ok := err.(*bambou.Error); ok && be != nil {
+		msg := strings.ToLower(be.Title + " " + be.Description)
+		return strings.Contains(msg, "not found") || strings.Contains(msg, "cannot find") || strings.Contains(msg, "does not exist")
+	}
+	return false
+}